@@ -0,0 +1,26 @@
+package api
+
+import "time"
+
+// Header returns the value of the header key, or "" if m has no
+// such header.
+func (m *Message) Header(key string) string {
+	return m.GetHeaders()[key]
+}
+
+// SetHeader sets the header key to value.
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+// Time returns the time at which m was sent, or the zero time if m
+// has no timestamp.
+func (m *Message) Time() time.Time {
+	if m.GetTimestamp() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.Timestamp)
+}
@@ -17,6 +17,17 @@ type MessageTooLong struct {
 	Msg string
 }
 
+// MessageRejected is returned by Send when the recipient received
+// the message but refused to accept it, for example because its
+// receive queue was full.  Msg is the reason given by the recipient.
+type MessageRejected struct {
+	Msg string
+	// Temporary is set if the recipient expects the reason to
+	// clear, so that the message may be accepted if it is sent
+	// again later.
+	Temporary bool
+}
+
 // MessageService provides a messaging service that can send and
 // receive api.Message messages.  Each message has a sender, a
 // receiver, and a byte buffer containing the message data.
@@ -31,8 +42,9 @@ type MessageService interface {
 	// named recipient and send it, provided that the recipient
 	// can be found.  If the marshalled message would be larger
 	// than MaxMessageLen, it should return a MessageTooLong
-	// error.  If any other error occurs, it should return an
-	// appropriate error.
+	// error.  If the recipient refuses the message, it should
+	// return a MessageRejected error.  If any other error occurs,
+	// it should return an appropriate error.
 	Send(recipient string, data []byte) error
 
 	// Close closes this MessageService, releasing the listening
@@ -48,3 +60,7 @@ type MessageService interface {
 func (err *MessageTooLong) Error() string {
 	return err.Msg
 }
+
+func (err *MessageRejected) Error() string {
+	return "message rejected: " + err.Msg
+}
@@ -11,9 +11,15 @@ policies for more package.
 package main
 
 import (
+	"bytes"
 	"cse586.messageservice/api"
 	"cse586.messageservice/given/detector"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/given/logging"
 	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/metrics"
+	"encoding/binary"
+	"flag"
 	"fmt"
 	"os"
 	"sync/atomic"
@@ -31,39 +37,106 @@ import (
 // failed", where [neighbor] is the ID of the failed neighbor.
 //
 // The command line arguments are:
-// heartbeat id neighbor1 [neighbor2 ...]
+// heartbeat [-transport tcp|udp] [-metrics addr] [-log-level level] id neighbor1 [neighbor2 ...]
 //
 // If the command is given fewer than 3 total arguments (program name,
 // own ID, one neighbor), it should print an error message and exit
 // with a nonzero value.
+//
+// The -transport flag selects whether heartbeats are sent over TCP
+// connections (the default) or as UDP datagrams.  Every heartbeat
+// process in a group must use the same transport.
+//
+// The -metrics flag serves the metrics of the message service and of
+// the detector in the Prometheus text format at http://addr/metrics.
+// Each heartbeat carries the time it was sent, and a neighbor answers
+// it with a reply carrying the same time, so that the heartbeat
+// round-trip time is measured from sending a heartbeat to receiving
+// the reply, over either transport.  Only configured neighbors are
+// answered or measured.
+//
+// The -log-level flag selects the least important events, one of
+// debug, info, warn, or error, that are logged to standard error.
+// Suspected failures are logged at warn level and recoveries at info
+// level, in addition to the "[neighbor] failed" lines printed to
+// standard output.
 
 var heartBeatMsgText = [...]byte{0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x2c,
 	0x20, 0x77, 0x6f, 0x72, 0x6c, 0x64, 0x21}
 
+// heartBeatReplyText begins a reply to a heartbeat.
+var heartBeatReplyText = []byte("Hello, back!")
+
+// stamped returns text followed by the time t.
+func stamped(text []byte, t time.Time) []byte {
+	data := make([]byte, len(text), len(text)+8)
+	copy(data, text)
+	return binary.BigEndian.AppendUint64(data, uint64(t.UnixNano()))
+}
+
+// stamp returns the time carried by data, if it is text followed by
+// a time.
+func stamp(data, text []byte) (time.Time, bool) {
+	if len(data) != len(text)+8 || !bytes.HasPrefix(data, text) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data[len(text):]))), true
+}
+
 // var heartBeatMsgText = [...]byte{}
 const (
 	maxMonitorNumber = 1024
 )
 
 func main() {
-	argsNumber := len(os.Args)
+	transport := flag.String("transport", "tcp", "heartbeat transport, tcp or udp")
+	metricsAddr := flag.String("metrics", "", "serve metrics at `addr`, such as localhost:9100")
+	level := logging.LevelError
+	flag.Var(&level, "log-level", "log events at `level` or above: debug, info, warn, or error")
+	flag.Parse()
+	args := append(os.Args[:1], flag.Args()...)
+	logger := logging.New(os.Stderr, level)
+	directory.SetLogger(logger)
+
+	reg := metrics.NewRegistry()
+	rtt := reg.Histogram("heartbeat_rtt_seconds", "Time from sending a heartbeat to receiving its reply, by neighbor.", nil, "peer")
+	suspicions := reg.Counter("heartbeat_suspicions_total", "Times a neighbor was suspected to have failed.", "peer")
+	if *metricsAddr != "" {
+		if _, err := reg.Serve(*metricsAddr); err != nil {
+			logger.Error("cannot serve metrics", "addr", *metricsAddr, "error", err)
+			os.Exit(-1)
+		}
+	}
+
+	opts := impl.Options{Metrics: reg, Logger: logger}
+	switch *transport {
+	case "tcp":
+		opts.Transport = impl.StreamTransport
+	case "udp":
+		opts.Transport = impl.DatagramTransport
+	default:
+		logger.Error("unknown transport", "transport", *transport)
+		os.Exit(-1)
+	}
+
+	argsNumber := len(args)
 	if argsNumber < 3 {
-		// fmt.Fprintln(os.Stderr, "lack of parameters, should be used like:heartbeat id neighbor1 [neighbor2 ...]")
+		logger.Error("missing arguments", "usage", "heartbeat id neighbor1 [neighbor2 ...]")
 		os.Exit(-1)
 	}
 
 	var neighbors []string
-	var ms api.MessageService
+	var ms impl.Service
 	//var sender string
 	var err error
-	for i, v := range os.Args {
+	for i, v := range args {
 		if i == 0 {
 			continue
 		} else if i == 1 {
 			//sender = v
-			ms, err = impl.NewMessageService(v)
+			ms, err = impl.NewMessageServiceWithOptions(v, opts)
 			if err != nil {
-				// fmt.Printf("%s failed\n", v)
+				logger.Error("cannot create message service", "id", v, "error", err)
 				os.Exit(-1)
 			}
 
@@ -81,7 +154,13 @@ func main() {
 		for {
 			for _, neighbor := range neighbors {
 				go func(neighbor string) {
-					ms.Send(neighbor, heartBeatMsgText[:])
+					// Heartbeats go ahead of any
+					// other traffic to the neighbor.
+					ms.SendMessage(&api.Message{
+						Recipient: neighbor,
+						Data:      stamped(heartBeatMsgText[:], time.Now()),
+						Priority:  api.Priority_CONTROL,
+					})
 				}(neighbor)
 			}
 			time.Sleep(detector.BeatInterval)
@@ -89,6 +168,7 @@ func main() {
 	}()
 
 	var lastReceivedTimestamp [maxMonitorNumber]int64
+	var suspected [maxMonitorNumber]int32
 	startTimestamp := int64(time.Now().UnixNano())
 	for i := 0; i < maxMonitorNumber; i++ {
 		lastReceivedTimestamp[i] = int64(startTimestamp)
@@ -103,6 +183,18 @@ func main() {
 			for i, v := range neighbors {
 				if v == rmsg.Sender {
 					atomic.StoreInt64(&lastReceivedTimestamp[i], int64(curTimestamp))
+					if atomic.CompareAndSwapInt32(&suspected[i], 1, 0) {
+						logger.Info("neighbor recovered", "peer", v)
+					}
+					if sent, ok := stamp(rmsg.Data, heartBeatReplyText); ok {
+						rtt.With(v).Observe(time.Since(sent).Seconds())
+					} else if sent, ok := stamp(rmsg.Data, heartBeatMsgText[:]); ok {
+						go ms.SendMessage(&api.Message{
+							Recipient: v,
+							Data:      stamped(heartBeatReplyText, sent),
+							Priority:  api.Priority_CONTROL,
+						})
+					}
 				}
 			}
 		}
@@ -115,6 +207,9 @@ func main() {
 			gapTimeSec := curTimestamp - atomic.LoadInt64(&lastReceivedTimestamp[i])
 			if gapTimeSec >= int64(detector.TimeoutDuration.Nanoseconds()) {
 				fmt.Printf("%s failed\n", v)
+				logger.Warn("neighbor failed", "peer", v, "silent", time.Duration(gapTimeSec))
+				suspicions.With(v).Inc()
+				atomic.StoreInt32(&suspected[i], 1)
 				atomic.StoreInt64(&lastReceivedTimestamp[i], int64(curTimestamp))
 			}
 		}
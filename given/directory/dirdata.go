@@ -18,9 +18,9 @@ package directory
 // A sample directory with appropriate listen addresses for some
 // famous figures in distributed systems.
 var directory map[string]*dirEntry = map[string]*dirEntry{
-	"gray":    {"gray", "localhost:4586", false},
-	"lamport": {"lamport", "localhost:5486", false},
-	"lynch":   {"lynch", "localhost:1986", false},
-	"mills":   {"mills", "localhost:5905", false},
-	"postel":  {"postel", "localhost:1943", false},
+	"gray":    {"gray", []string{"localhost:4586"}, false},
+	"lamport": {"lamport", []string{"localhost:5486"}, false},
+	"lynch":   {"lynch", []string{"localhost:1986"}, false},
+	"mills":   {"mills", []string{"localhost:5905"}, false},
+	"postel":  {"postel", []string{"localhost:1943"}, false},
 }
@@ -10,7 +10,8 @@ policies for more package.
 
 // The directory package provides a directory service for mapping
 // logical process names to socket addresses.  It exposes two public
-// functions, Register and Lookup.  When a MessageService
+// functions, Register and Lookup, which operate on the default
+// channel-based directory.  When a MessageService
 // impelmentation starts up, it should call the Register function to
 // register itself with the directory service.  If it does not know
 // its own name, the directory service can assign it one at that time.
@@ -30,14 +31,99 @@ policies for more package.
 // return.  This implementation may be switched out for a completely
 // different implementation, or a different database, during grading.
 //
+// Code that must not depend on a particular implementation should
+// accept a Directory, which is implemented by Default (the
+// channel-based directory backed by the data in dirdata.go), by
+// Static (an in-memory directory useful for testing), and by DNS
+// (which resolves IDs through DNS SRV records and a hosts file).
+//
 // The sample directory provided to you can be found in dirdata.go.
 package directory
 
 import (
 	"errors"
 	"fmt"
+	"sync"
+)
+
+// Directory is the interface implemented by every directory backend.
+type Directory interface {
+	// Register registers id with the directory, as described
+	// for the package-level Register function.
+	Register(id string) (string, error)
+
+	// Lookup returns the preferred address of id, or !ok if id
+	// is unknown.
+	Lookup(id string) (string, bool)
+
+	// LookupAll returns every address of id in order of
+	// preference, or !ok if id is unknown.
+	LookupAll(id string) ([]string, bool)
+
+	// List returns a snapshot of every entry in the directory.
+	List() []Entry
+
+	// Watch returns a channel that receives an Event whenever an
+	// entry changes, and a function that cancels the watch and
+	// closes the channel.  Events are dropped, rather than
+	// blocking the directory, if the watcher falls behind.
+	Watch() (<-chan Event, func())
+}
+
+// Entry is the public view of a directory entry.
+//
+// An address is normally a host:port for TCP, but may be prefixed
+// with a network name and a colon (e.g. "tcp6:[::1]:1986") to select
+// a particular transport for that address.
+type Entry struct {
+	ID        string   // ID is the global ID of this entry
+	Address   string   // Address is the preferred listen address
+	Addresses []string // Addresses lists every listen address, in order
+	InUse     bool     // InUse is true if this ID has been registered
+}
+
+// EventType describes the change reported by an Event.
+type EventType int
+
+const (
+	// Registered reports that an ID has been registered.
+	Registered EventType = iota
+	// Unregistered reports that an ID has been released.
+	Unregistered
+	// Updated reports that an entry was added or that its
+	// address changed.
+	Updated
+	// Removed reports that an entry was deleted.
+	Removed
 )
 
+var eventTypeNames = [...]string{
+	Registered:   "registered",
+	Unregistered: "unregistered",
+	Updated:      "updated",
+	Removed:      "removed",
+}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+	return eventTypeNames[t]
+}
+
+// Event is delivered to watchers when a directory entry changes.
+type Event struct {
+	Type  EventType
+	Entry Entry
+}
+
+// Default is the channel-based directory served by dirService.  The
+// package-level functions in this file operate on it.
+var Default Directory = chanDirectory{}
+
+// watchBuffer is the capacity of the channels returned by Watch.
+const watchBuffer = 16
+
 // NoSuchID is the error returned when a directory registration or
 // lookup attempts to reference an invalid ID.
 type NoSuchID string
@@ -52,7 +138,7 @@ func Register(id string) (string, error) {
 	// This channel will be used to receive the result of the
 	// registration request from the directory service goroutine.
 	c := make(chan dirResult)
-	requests <- &dirRequest{id, dir_REGISTER, c}
+	requests <- &dirRequest{id, dir_REGISTER, c, nil}
 
 	// The directory server will eventually service the request
 	// sent above.  When it's done, it will send us back a
@@ -61,35 +147,97 @@ func Register(id string) (string, error) {
 	// is nil the following return would crash.
 	result := <-c
 	if result.err != nil {
+		defaultLogger().Warn("directory registration failed", "id", id, "error", result.err)
 		return "", result.err
 	}
 	return result.entry.id, result.err
 }
 
 // Lookup searches the directory for the given ID and returns its
-// address if known, or !ok if it is unknown.  A known address will be
-// returned whether or not it has been registered.
+// preferred address if known, or !ok if it is unknown.  A known
+// address will be returned whether or not it has been registered.
 func Lookup(id string) (string, bool) {
+	addrs, ok := LookupAll(id)
+	if !ok {
+		return "", false
+	}
+	return addrs[0], true
+}
+
+// LookupAll is like Lookup, but returns every address of the given
+// ID in order of preference.
+func LookupAll(id string) ([]string, bool) {
 	c := make(chan dirResult)
-	requests <- &dirRequest{id, dir_LOOKUP, c}
+	requests <- &dirRequest{id, dir_LOOKUP, c, nil}
 	result := <-c
 	if result.entry == nil {
-		return "", false
+		defaultLogger().Debug("directory lookup of unknown ID", "id", id)
+		return nil, false
+	}
+	return append([]string(nil), result.entry.addresses...), true
+}
+
+// List returns a snapshot of every entry in the directory, in no
+// particular order.
+func List() []Entry {
+	c := make(chan dirResult)
+	requests <- &dirRequest{"", dir_LIST, c, nil}
+	return (<-c).entries
+}
+
+// Watch returns a channel that receives directory events and a
+// function that cancels the watch.  See Directory.Watch.
+func Watch() (<-chan Event, func()) {
+	w := make(chan Event, watchBuffer)
+	c := make(chan dirResult)
+	requests <- &dirRequest{"", dir_WATCH, c, w}
+	<-c
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c := make(chan dirResult)
+			requests <- &dirRequest{"", dir_UNWATCH, c, w}
+			<-c
+		})
 	}
-	return result.entry.address, true
+	return w, cancel
 }
 
+// chanDirectory adapts the package-level functions to the Directory
+// interface.
+type chanDirectory struct{}
+
+func (chanDirectory) Register(id string) (string, error)   { return Register(id) }
+func (chanDirectory) Lookup(id string) (string, bool)      { return Lookup(id) }
+func (chanDirectory) LookupAll(id string) ([]string, bool) { return LookupAll(id) }
+func (chanDirectory) List() []Entry                        { return List() }
+func (chanDirectory) Watch() (<-chan Event, func())        { return Watch() }
+
 func (err NoSuchID) Error() string {
 	return fmt.Sprintf("Unknown ID '%s'", string(err))
 }
 
 // dirEntry represents a directory entry.
 //
-// id and address are immutable fields, inUse is mutable.
+// id and addresses are immutable fields, inUse is mutable.
 type dirEntry struct {
-	id      string // id is the global ID of this entry
-	address string // address is the listen address for this ID
-	inUse   bool   // inUse is true if this ID has been registered
+	id        string   // id is the global ID of this entry
+	addresses []string // addresses are the listen addresses for this ID
+	inUse     bool     // inUse is true if this ID has been registered
+}
+
+// public returns a copy of entry suitable for returning to callers
+// outside of the directory service goroutine.
+func (entry *dirEntry) public() Entry {
+	return newEntry(entry.id, entry.addresses, entry.inUse)
+}
+
+// newEntry creates an Entry with its own copy of addrs, which must
+// not be empty.
+func newEntry(id string, addrs []string, inUse bool) Entry {
+	addrs = append([]string(nil), addrs...)
+	return Entry{id, addrs[0], addrs, inUse}
 }
 
 // dirAction represents an action that can be taken by the directory service.
@@ -103,6 +251,13 @@ const (
 	dir_UNREGISTER
 	// dir_LOOKUP requests a lookup on an ID.
 	dir_LOOKUP
+	// dir_LIST requests a snapshot of every entry.
+	dir_LIST
+	// dir_WATCH adds the request's watcher channel to the set of
+	// channels notified of changes.
+	dir_WATCH
+	// dir_UNWATCH removes and closes a watcher channel.
+	dir_UNWATCH
 )
 
 // dirRequest is a request to the directory service.
@@ -115,6 +270,9 @@ type dirRequest struct {
 	// c is where the result of the request will be returned by
 	// the directory service goroutine.
 	c chan<- dirResult
+	// watcher is the channel being added or removed by
+	// dir_WATCH and dir_UNWATCH.
+	watcher chan Event
 }
 
 // dirResult is a result returned by the directory service.
@@ -126,6 +284,8 @@ type dirResult struct {
 	entry *dirEntry
 	// err is non-nil if the request could not be satisfied.
 	err error
+	// entries is the result of a dir_LIST request.
+	entries []Entry
 }
 
 // requests is the gateway between Register and Lookup (and
@@ -135,7 +295,7 @@ var requests chan *dirRequest
 // Unregister from the directory service.  This does no error checking
 // and is used only for testing.
 func unregister(id string) {
-	requests <- &dirRequest{id, dir_UNREGISTER, nil}
+	requests <- &dirRequest{id, dir_UNREGISTER, nil, nil}
 }
 
 // dirService is the directory service goroutine.  This listens on the
@@ -152,6 +312,20 @@ func unregister(id string) {
 // directory is always consistent.  In essence, dirService "owns" the
 // directory map and its contents.
 func dirService() {
+	// watchers is the set of channels returned by Watch.  Like
+	// the directory map, it is owned by this goroutine.
+	watchers := make(map[chan Event]struct{})
+	notify := func(t EventType, entry *dirEntry) {
+		ev := Event{t, entry.public()}
+		logEvent(defaultLogger(), ev)
+		for w := range watchers {
+			select {
+			case w <- ev:
+			default:
+			}
+		}
+	}
+
 	// ranging over a channel will "drain" the channel; that is,
 	// iterate over every message sent on the channel until the
 	// channel is closed.
@@ -168,7 +342,7 @@ func dirService() {
 			// requester by writing to the channel
 			// included in the dirRequest struct received
 			// over the request channel.
-			req.c <- dirResult{entry, nil}
+			req.c <- dirResult{entry, nil, nil}
 		case dir_REGISTER:
 			// Register a name if it exists in the map.
 			// If the requested name is "", choose and
@@ -180,7 +354,7 @@ func dirService() {
 					}
 				}
 				if entry.inUse {
-					req.c <- dirResult{nil, errors.New("No available IDs")}
+					req.c <- dirResult{nil, errors.New("No available IDs"), nil}
 				}
 			}
 			if entry == nil {
@@ -188,14 +362,15 @@ func dirService() {
 				// the directory service; if a process
 				// tries to register an ID that
 				// doesn't exist, we return NoSuchID.
-				req.c <- dirResult{nil, NoSuchID(req.id)}
+				req.c <- dirResult{nil, NoSuchID(req.id), nil}
 				continue
 			}
 			if entry.inUse {
-				req.c <- dirResult{nil, errors.New("Already registered")}
+				req.c <- dirResult{nil, errors.New("Already registered"), nil}
 			} else {
 				entry.inUse = true
-				req.c <- dirResult{entry, nil}
+				req.c <- dirResult{entry, nil, nil}
+				notify(Registered, entry)
 			}
 		case dir_UNREGISTER:
 			// This action is used only in testing.  It
@@ -203,9 +378,23 @@ func dirService() {
 			//
 			// This could be exposed, but the potential
 			// for error is large.
-			if found {
+			if found && entry.inUse {
 				entry.inUse = false
+				notify(Unregistered, entry)
+			}
+		case dir_LIST:
+			entries := make([]Entry, 0, len(directory))
+			for _, entry := range directory {
+				entries = append(entries, entry.public())
 			}
+			req.c <- dirResult{nil, nil, entries}
+		case dir_WATCH:
+			watchers[req.watcher] = struct{}{}
+			req.c <- dirResult{}
+		case dir_UNWATCH:
+			delete(watchers, req.watcher)
+			close(req.watcher)
+			req.c <- dirResult{}
 		}
 	}
 }
@@ -95,3 +95,26 @@ func TestAddressLookup(t *testing.T) {
 		t.Error("Bad address")
 	}
 }
+
+// TestListAndWatch ensures that List reports every entry and that a
+// watcher is notified of registration changes.
+func TestListAndWatch(t *testing.T) {
+	if len(List()) != len(directory) {
+		t.Error("List did not return every entry")
+	}
+
+	events, cancel := Watch()
+	defer cancel()
+	id, err := Register("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unregister(id)
+
+	for _, want := range []EventType{Registered, Unregistered} {
+		ev := <-events
+		if ev.Type != want || ev.Entry.ID != id {
+			t.Errorf("Unexpected event %+v", ev)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package directory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cse586.messageservice/given/logging"
+)
+
+// DNSConfig configures a directory created by NewDNS.
+type DNSConfig struct {
+	// Server is the host:port of the DNS server to query.  If
+	// empty, the first nameserver in /etc/resolv.conf is used.
+	Server string
+
+	// Domain is appended to every query.  The SRV record for an
+	// ID is _Service._Proto.ID.Domain.
+	Domain string
+
+	// Service and Proto name the SRV record; they default to
+	// "messageservice" and "tcp".
+	Service string
+	Proto   string
+
+	// HostsFile, if non-empty, names a hosts-style file (see
+	// readHostsFile) that is consulted before DNS.  Its entries
+	// never expire.
+	HostsFile string
+
+	// NegativeTTL is how long a failed lookup is remembered.  It
+	// defaults to five seconds.
+	NegativeTTL time.Duration
+
+	// Timeout bounds each DNS exchange.  It defaults to two
+	// seconds.
+	Timeout time.Duration
+
+	// Logger, if not nil, receives an event for each DNS query
+	// and its result.  Failed queries are logged at warn level.
+	Logger logging.Logger
+}
+
+// DNS is a Directory that resolves IDs through DNS SRV records and,
+// optionally, a hosts-style file.  Positive answers are cached for
+// the TTL of the records that produced them.
+//
+// DNS is read-only: Register only marks a resolvable ID as in use
+// within this process.
+type DNS struct {
+	cfg   DNSConfig
+	hosts map[string][]string
+
+	// now is the clock used for cache expiry; tests replace it.
+	now func() time.Time
+
+	mu       sync.Mutex
+	cache    map[string]*dnsCacheEntry
+	inUse    map[string]bool
+	watchers watcherSet
+}
+
+// dnsCacheEntry is the cached result of resolving one ID.  An entry
+// with no addresses records a negative answer.
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// NewDNS creates a DNS directory.  It returns an error if the hosts
+// file cannot be read or no DNS server can be determined.
+func NewDNS(cfg DNSConfig) (*DNS, error) {
+	if cfg.Service == "" {
+		cfg.Service = "messageservice"
+	}
+	if cfg.Proto == "" {
+		cfg.Proto = "tcp"
+	}
+	if cfg.NegativeTTL == 0 {
+		cfg.NegativeTTL = 5 * time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	cfg.Logger = logging.OrDiscard(cfg.Logger)
+	if cfg.Server == "" {
+		server, err := systemNameserver("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		cfg.Server = server
+	}
+
+	d := &DNS{
+		cfg:   cfg,
+		hosts: make(map[string][]string),
+		now:   time.Now,
+		cache: make(map[string]*dnsCacheEntry),
+		inUse: make(map[string]bool),
+	}
+	if cfg.HostsFile != "" {
+		hosts, err := readHostsFile(cfg.HostsFile)
+		if err != nil {
+			return nil, err
+		}
+		d.hosts = hosts
+	}
+	return d, nil
+}
+
+// Register implements Directory.  An empty id registers the first
+// unregistered ID from the hosts file in lexical order, since DNS
+// cannot be enumerated.
+func (d *DNS) Register(id string) (string, error) {
+	if id == "" {
+		d.mu.Lock()
+		for _, candidate := range sortedKeys(d.hosts) {
+			if !d.inUse[candidate] {
+				id = candidate
+				break
+			}
+		}
+		d.mu.Unlock()
+		if id == "" {
+			return "", errors.New("No available IDs")
+		}
+	}
+
+	addrs, ok := d.resolve(id)
+	if !ok {
+		return "", NoSuchID(id)
+	}
+	d.mu.Lock()
+	if d.inUse[id] {
+		d.mu.Unlock()
+		return "", errors.New("Already registered")
+	}
+	d.inUse[id] = true
+	d.mu.Unlock()
+	d.watchers.notify(Registered, newEntry(id, addrs, true))
+	return id, nil
+}
+
+// Unregister releases an ID registered through this directory.
+func (d *DNS) Unregister(id string) {
+	d.mu.Lock()
+	wasInUse := d.inUse[id]
+	delete(d.inUse, id)
+	d.mu.Unlock()
+	if addrs, ok := d.resolve(id); ok && wasInUse {
+		d.watchers.notify(Unregistered, newEntry(id, addrs, false))
+	}
+}
+
+// Lookup implements Directory.  When several SRV records match, the
+// address of the one with the lowest priority, and then the highest
+// weight, is returned.
+func (d *DNS) Lookup(id string) (string, bool) {
+	addrs, ok := d.resolve(id)
+	if !ok {
+		return "", false
+	}
+	return addrs[0], true
+}
+
+// LookupAll implements Directory.  Addresses are ordered as for
+// Lookup.
+func (d *DNS) LookupAll(id string) ([]string, bool) {
+	addrs, ok := d.resolve(id)
+	return append([]string(nil), addrs...), ok
+}
+
+// List implements Directory.  It reports the hosts file entries and
+// every ID with a cached positive answer, sorted by ID.
+func (d *DNS) List() []Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	addrs := make(map[string][]string, len(d.hosts)+len(d.cache))
+	for id, e := range d.cache {
+		if len(e.addrs) > 0 {
+			addrs[id] = e.addrs
+		}
+	}
+	for id, a := range d.hosts {
+		addrs[id] = a
+	}
+	entries := make([]Entry, 0, len(addrs))
+	for _, id := range sortedKeys(addrs) {
+		entries = append(entries, newEntry(id, addrs[id], d.inUse[id]))
+	}
+	return entries
+}
+
+// Watch implements Directory.  Besides registration changes, an
+// Updated event is delivered whenever resolving an ID yields a
+// different set of addresses than the cached answer.
+func (d *DNS) Watch() (<-chan Event, func()) {
+	return d.watchers.watch()
+}
+
+// resolve returns every known address for id, consulting the hosts
+// file, the cache, and finally DNS.  If a query fails outright, the
+// stale answer, if any, is returned, and the failure is cached for
+// NegativeTTL so that the server is not queried on every lookup.
+func (d *DNS) resolve(id string) ([]string, bool) {
+	if addrs, ok := d.hosts[id]; ok {
+		return addrs, true
+	}
+
+	d.mu.Lock()
+	cached := d.cache[id]
+	d.mu.Unlock()
+	if cached != nil && d.now().Before(cached.expires) {
+		return cached.addrs, len(cached.addrs) > 0
+	}
+
+	addrs, ttl, err := d.query(id)
+	if err != nil {
+		d.cfg.Logger.Warn("directory query failed", "id", id, "server", d.cfg.Server, "stale", cached != nil, "error", err)
+		var stale []string
+		if cached != nil {
+			stale = cached.addrs
+		}
+		d.mu.Lock()
+		d.cache[id] = &dnsCacheEntry{stale, d.now().Add(d.cfg.NegativeTTL)}
+		d.mu.Unlock()
+		return stale, len(stale) > 0
+	}
+	if len(addrs) == 0 {
+		ttl = d.cfg.NegativeTTL
+	}
+	d.cfg.Logger.Debug("directory query", "id", id, "addrs", addrs, "ttl", ttl)
+
+	d.mu.Lock()
+	d.cache[id] = &dnsCacheEntry{addrs, d.now().Add(ttl)}
+	inUse := d.inUse[id]
+	d.mu.Unlock()
+
+	if len(addrs) > 0 && (cached == nil || !EqualAddrs(cached.addrs, addrs)) {
+		d.watchers.notify(Updated, newEntry(id, addrs, inUse))
+	}
+	return addrs, len(addrs) > 0
+}
+
+// query looks up the SRV records for id and returns the addresses
+// they name, in preference order, and the TTL for which the answer
+// may be cached.  A name that does not exist yields no addresses and
+// no error.
+func (d *DNS) query(id string) ([]string, time.Duration, error) {
+	name := fmt.Sprintf("_%s._%s.%s.%s", d.cfg.Service, d.cfg.Proto, id, d.cfg.Domain)
+	req := &dnsMsg{
+		id:        uint16(rand.Uint32()),
+		flags:     dnsFlagRecursion,
+		questions: []dnsQuestion{{name, dnsTypeSRV, dnsClassIN}},
+	}
+
+	resp, err := d.exchange("udp", req)
+	if err == nil && resp.flags&dnsFlagTruncated != 0 {
+		resp, err = d.exchange("tcp", req)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	switch rcode := resp.flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("DNS query for %s failed with rcode %d", name, rcode)
+	}
+
+	var srvs []dnsRR
+	for _, rr := range resp.answers {
+		// A target of "." means the service is decidedly not
+		// available at this name.
+		if rr.typ == dnsTypeSRV && rr.target != "." {
+			srvs = append(srvs, rr)
+		}
+	}
+	if len(srvs) == 0 {
+		return nil, 0, nil
+	}
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].priority != srvs[j].priority {
+			return srvs[i].priority < srvs[j].priority
+		}
+		return srvs[i].weight > srvs[j].weight
+	})
+
+	ttl := srvs[0].ttl
+	addrs := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		if srv.ttl < ttl {
+			ttl = srv.ttl
+		}
+		host := strings.TrimSuffix(srv.target, ".")
+		// Prefer glue records from the additional section, so
+		// that the address does not need to be resolved again.
+		for _, rr := range resp.additional {
+			if (rr.typ == dnsTypeA || rr.typ == dnsTypeAAAA) && strings.EqualFold(rr.name, srv.target) {
+				host = rr.ip.String()
+				if rr.ttl < ttl {
+					ttl = rr.ttl
+				}
+				break
+			}
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(srv.port))))
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+// exchange sends req to the configured server over network ("udp"
+// or "tcp") and returns the matching response.
+func (d *DNS) exchange(network string, req *dnsMsg) (*dnsMsg, error) {
+	conn, err := net.DialTimeout(network, d.cfg.Server, d.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(d.cfg.Timeout))
+
+	out := req.pack()
+	var in []byte
+	if network == "tcp" {
+		// DNS over TCP prefixes each message with its length.
+		out = append(binary.BigEndian.AppendUint16(nil, uint16(len(out))), out...)
+		if _, err := conn.Write(out); err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(conn)
+		var hdr [2]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, err
+		}
+		in = make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if _, err := io.ReadFull(r, in); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(out); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		in = buf[:n]
+	}
+
+	resp, err := unpackDNS(in)
+	if err != nil {
+		return nil, err
+	}
+	if resp.id != req.id || resp.flags&dnsFlagResponse == 0 {
+		return nil, errDNSFormat
+	}
+	return resp, nil
+}
+
+// systemNameserver returns the first nameserver listed in the
+// resolv.conf-format file at path, as a host:port.
+func systemNameserver(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no nameserver in %s", path)
+}
+
+// EqualAddrs reports whether a and b hold the same addresses in the
+// same order, as when comparing two results of LookupAll.
+func EqualAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,231 @@
+package directory
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubDNS is an in-process DNS server that answers SRV queries from
+// a fixed table and counts the queries it receives.
+type stubDNS struct {
+	conn    net.PacketConn
+	records map[string][]dnsRR // keyed by lowercase query name
+	glue    []dnsRR
+	queries int32
+	// fail, if nonzero, makes every query fail with SERVFAIL.
+	fail int32
+}
+
+// newStubDNS starts a stub server on a loopback UDP port.
+func newStubDNS(t *testing.T, records map[string][]dnsRR, glue []dnsRR) *stubDNS {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start stub DNS server: %v", err)
+	}
+	s := &stubDNS{conn: conn, records: records, glue: glue}
+	go s.serve()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *stubDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.queries, 1)
+		req, err := unpackDNS(buf[:n])
+		if err != nil || len(req.questions) != 1 {
+			continue
+		}
+		resp := &dnsMsg{id: req.id, flags: dnsFlagResponse, questions: req.questions}
+		if atomic.LoadInt32(&s.fail) != 0 {
+			resp.flags |= dnsRcodeServFail
+		} else if answers, ok := s.records[strings.ToLower(req.questions[0].name)]; ok {
+			resp.answers = answers
+			resp.additional = s.glue
+		} else {
+			resp.flags |= dnsRcodeNXDomain
+		}
+		s.conn.WriteTo(resp.pack(), addr)
+	}
+}
+
+// TestDNSMessageRoundTrip ensures that a packed message, including
+// compressed names written by other encoders, decodes correctly.
+func TestDNSMessageRoundTrip(t *testing.T) {
+	m := &dnsMsg{
+		id:        7,
+		flags:     dnsFlagResponse,
+		questions: []dnsQuestion{{"a.example.", dnsTypeSRV, dnsClassIN}},
+		answers: []dnsRR{{name: "a.example.", typ: dnsTypeSRV, class: dnsClassIN,
+			ttl: 30, priority: 1, weight: 2, port: 3, target: "b.example."}},
+		additional: []dnsRR{{name: "b.example.", typ: dnsTypeA, class: dnsClassIN,
+			ttl: 30, ip: net.IPv4(10, 0, 0, 1)}},
+	}
+	b := m.pack()
+	got, err := unpackDNS(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.id != 7 || got.answers[0].target != "b.example." || got.answers[0].port != 3 ||
+		!got.additional[0].ip.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("Decoded message differs: %+v", got)
+	}
+
+	// Replace the answer's owner name with a pointer to the
+	// question name at offset 12.
+	q := 12 + len(appendDNSName(nil, "a.example.")) + 4
+	compressed := append(append(append([]byte(nil), b[:q]...), 0xc0, 12), b[q+len(appendDNSName(nil, "a.example.")):]...)
+	if got, err = unpackDNS(compressed); err != nil || got.answers[0].name != "a.example." {
+		t.Errorf("Compressed name decoded as %v, %v", got, err)
+	}
+
+	if _, err := unpackDNS(b[:len(b)-3]); err == nil {
+		t.Error("Truncated message decoded without error")
+	}
+}
+
+// TestDNSLookup resolves IDs through a stub server and checks SRV
+// ordering, glue records, caching, TTL expiry, and negative answers.
+func TestDNSLookup(t *testing.T) {
+	name := "_messageservice._tcp.lynch.test."
+	stub := newStubDNS(t, map[string][]dnsRR{
+		name: {
+			{name: name, typ: dnsTypeSRV, class: dnsClassIN, ttl: 60,
+				priority: 20, weight: 1, port: 2000, target: "backup.test."},
+			{name: name, typ: dnsTypeSRV, class: dnsClassIN, ttl: 30,
+				priority: 10, weight: 1, port: 1986, target: "primary.test."},
+		},
+	}, []dnsRR{
+		{name: "primary.test.", typ: dnsTypeA, class: dnsClassIN, ttl: 60, ip: net.IPv4(127, 0, 0, 2)},
+	})
+
+	d, err := NewDNS(DNSConfig{Server: stub.conn.LocalAddr().String(), Domain: "test."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1000, 0)
+	d.now = func() time.Time { return clock }
+
+	if addr, ok := d.Lookup("lynch"); !ok || addr != "127.0.0.2:1986" {
+		t.Errorf("Lookup(lynch) = %q, %v", addr, ok)
+	}
+	if addrs, _ := d.resolve("lynch"); len(addrs) != 2 || addrs[1] != "backup.test:2000" {
+		t.Errorf("resolve(lynch) = %v", addrs)
+	}
+	if n := atomic.LoadInt32(&stub.queries); n != 1 {
+		t.Errorf("Expected 1 query before expiry, got %d", n)
+	}
+
+	clock = clock.Add(31 * time.Second)
+	d.Lookup("lynch")
+	if n := atomic.LoadInt32(&stub.queries); n != 2 {
+		t.Errorf("Expected a new query after the TTL expired, got %d", n)
+	}
+
+	if _, ok := d.Lookup("gray"); ok {
+		t.Error("Lookup of nonexistent name succeeded")
+	}
+	d.Lookup("gray")
+	if n := atomic.LoadInt32(&stub.queries); n != 3 {
+		t.Errorf("Negative answer was not cached, %d queries", n)
+	}
+
+	// A failing server is not asked again until NegativeTTL has
+	// passed, and the stale answer is used meanwhile.
+	clock = clock.Add(31 * time.Second)
+	atomic.StoreInt32(&stub.fail, 1)
+	for i := 0; i < 3; i++ {
+		if addr, ok := d.Lookup("lynch"); !ok || addr != "127.0.0.2:1986" {
+			t.Errorf("Stale Lookup(lynch) = %q, %v", addr, ok)
+		}
+	}
+	if n := atomic.LoadInt32(&stub.queries); n != 4 {
+		t.Errorf("Expected one query to the failing server, got %d", n-3)
+	}
+	clock = clock.Add(6 * time.Second)
+	d.Lookup("lynch")
+	if n := atomic.LoadInt32(&stub.queries); n != 5 {
+		t.Errorf("Expected a retry after NegativeTTL, got %d queries", n)
+	}
+	atomic.StoreInt32(&stub.fail, 0)
+
+	if _, err := d.Register("lynch"); err != nil {
+		t.Error(err)
+	}
+	if _, err := d.Register("lynch"); err == nil {
+		t.Error("Second registration of registered ID succeeded")
+	}
+}
+
+// TestDNSHostsFile ensures that the hosts file is consulted before
+// DNS and that it supports several IDs per line.
+func TestDNSHostsFile(t *testing.T) {
+	stub := newStubDNS(t, nil, nil)
+	path := filepath.Join(t.TempDir(), "hosts")
+	data := "# test hosts\n127.0.0.1:4586 gray lamport\n\n[::1]:1986 lynch # comment\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDNS(DNSConfig{Server: stub.conn.LocalAddr().String(), HostsFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]string{
+		"gray":    "127.0.0.1:4586",
+		"lamport": "127.0.0.1:4586",
+		"lynch":   "[::1]:1986",
+	} {
+		if addr, ok := d.Lookup(id); !ok || addr != want {
+			t.Errorf("Lookup(%s) = %q, %v", id, addr, ok)
+		}
+	}
+	if n := atomic.LoadInt32(&stub.queries); n != 0 {
+		t.Errorf("Hosts file lookups queried DNS %d times", n)
+	}
+	if id, err := d.Register(""); err != nil || id != "gray" {
+		t.Errorf("Register(\"\") = %q, %v", id, err)
+	}
+	if len(d.List()) != 3 {
+		t.Errorf("Unexpected entries %+v", d.List())
+	}
+
+	if _, err := parseHosts(strings.NewReader("127.0.0.1:1\n"), "bad"); err == nil {
+		t.Error("Line without IDs parsed without error")
+	}
+}
+
+// TestParseHostsNetworks ensures that hosts files accept the
+// network-prefixed addresses that Entry allows.
+func TestParseHostsNetworks(t *testing.T) {
+	data := "tcp:127.0.0.1:1 a\ntcp4:127.0.0.1:2 b\ntcp6:[::1]:3 c\nunix:/tmp/d.sock d\n"
+	hosts, err := parseHosts(strings.NewReader(data), "networks")
+	if err != nil {
+		t.Fatalf("parseHosts failed: %v", err)
+	}
+	for id, want := range map[string]string{
+		"a": "tcp:127.0.0.1:1",
+		"b": "tcp4:127.0.0.1:2",
+		"c": "tcp6:[::1]:3",
+		"d": "unix:/tmp/d.sock",
+	} {
+		if got := hosts[id]; len(got) != 1 || got[0] != want {
+			t.Errorf("%s has addresses %v, expected %s", id, got, want)
+		}
+	}
+	for _, bad := range []string{"tcp6:[::1] e", "unix: f", "udp:127.0.0.1:4 g", "localhost h"} {
+		if _, err := parseHosts(strings.NewReader(bad), "bad"); err == nil {
+			t.Errorf("%q parsed without error", bad)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package directory
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// This file contains a minimal encoder and decoder for the DNS wire
+// format (RFC 1035), sufficient to issue SRV queries and to interpret
+// SRV, A, and AAAA records in the response.  Records of other types
+// are decoded far enough to be skipped.
+
+// DNS record types and classes used by this package.
+const (
+	dnsTypeA    uint16 = 1
+	dnsTypeAAAA uint16 = 28
+	dnsTypeSRV  uint16 = 33
+	dnsClassIN  uint16 = 1
+)
+
+// Bits and fields of the DNS header flags word.
+const (
+	dnsFlagResponse  uint16 = 1 << 15
+	dnsFlagTruncated uint16 = 1 << 9
+	dnsFlagRecursion uint16 = 1 << 8
+	dnsRcodeMask     uint16 = 0xf
+	dnsRcodeServFail uint16 = 2
+	dnsRcodeNXDomain uint16 = 3
+)
+
+// errDNSFormat is returned when a DNS message cannot be decoded.
+var errDNSFormat = errors.New("malformed DNS message")
+
+// dnsMsg is a decoded DNS message.  Authority records are decoded
+// into additional, as this package does not distinguish them.
+type dnsMsg struct {
+	id         uint16
+	flags      uint16
+	questions  []dnsQuestion
+	answers    []dnsRR
+	additional []dnsRR
+}
+
+// dnsQuestion is an entry in the question section of a DNS message.
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+// dnsRR is a resource record.  Only the fields appropriate to typ
+// are meaningful.
+type dnsRR struct {
+	name  string
+	typ   uint16
+	class uint16
+	ttl   uint32
+
+	// priority, weight, port, and target are the SRV data.
+	priority uint16
+	weight   uint16
+	port     uint16
+	target   string
+
+	// ip is the A or AAAA data.
+	ip net.IP
+}
+
+// pack encodes m in wire format.  Names are never compressed.
+func (m *dnsMsg) pack() []byte {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.id)
+	binary.BigEndian.PutUint16(b[2:], m.flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.additional)))
+	for _, q := range m.questions {
+		b = appendDNSName(b, q.name)
+		b = binary.BigEndian.AppendUint16(b, q.qtype)
+		b = binary.BigEndian.AppendUint16(b, q.class)
+	}
+	for _, rrs := range [][]dnsRR{m.answers, m.additional} {
+		for _, rr := range rrs {
+			b = rr.pack(b)
+		}
+	}
+	return b
+}
+
+// pack appends the wire encoding of rr to b.
+func (rr *dnsRR) pack(b []byte) []byte {
+	b = appendDNSName(b, rr.name)
+	b = binary.BigEndian.AppendUint16(b, rr.typ)
+	b = binary.BigEndian.AppendUint16(b, rr.class)
+	b = binary.BigEndian.AppendUint32(b, rr.ttl)
+	lenAt := len(b)
+	b = append(b, 0, 0)
+	switch rr.typ {
+	case dnsTypeSRV:
+		b = binary.BigEndian.AppendUint16(b, rr.priority)
+		b = binary.BigEndian.AppendUint16(b, rr.weight)
+		b = binary.BigEndian.AppendUint16(b, rr.port)
+		b = appendDNSName(b, rr.target)
+	case dnsTypeA:
+		b = append(b, rr.ip.To4()...)
+	case dnsTypeAAAA:
+		b = append(b, rr.ip.To16()...)
+	}
+	binary.BigEndian.PutUint16(b[lenAt:], uint16(len(b)-lenAt-2))
+	return b
+}
+
+// appendDNSName appends name, in dotted form, to b as a sequence of
+// labels.
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// unpackDNS decodes a DNS message.
+func unpackDNS(b []byte) (*dnsMsg, error) {
+	if len(b) < 12 {
+		return nil, errDNSFormat
+	}
+	m := &dnsMsg{
+		id:    binary.BigEndian.Uint16(b[0:]),
+		flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	an := int(binary.BigEndian.Uint16(b[6:]))
+	ns := int(binary.BigEndian.Uint16(b[8:]))
+	ar := int(binary.BigEndian.Uint16(b[10:]))
+
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, n, err := readDNSName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(b) {
+			return nil, errDNSFormat
+		}
+		m.questions = append(m.questions, dnsQuestion{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(b[off:]),
+			class: binary.BigEndian.Uint16(b[off+2:]),
+		})
+		off += 4
+	}
+	for i := 0; i < an+ns+ar; i++ {
+		rr, n, err := readDNSRR(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if i < an {
+			m.answers = append(m.answers, rr)
+		} else {
+			m.additional = append(m.additional, rr)
+		}
+	}
+	return m, nil
+}
+
+// readDNSRR decodes the resource record at b[off:], returning it and
+// the offset of the following record.
+func readDNSRR(b []byte, off int) (dnsRR, int, error) {
+	var rr dnsRR
+	name, off, err := readDNSName(b, off)
+	if err != nil {
+		return rr, 0, err
+	}
+	if off+10 > len(b) {
+		return rr, 0, errDNSFormat
+	}
+	rr.name = name
+	rr.typ = binary.BigEndian.Uint16(b[off:])
+	rr.class = binary.BigEndian.Uint16(b[off+2:])
+	rr.ttl = binary.BigEndian.Uint32(b[off+4:])
+	rdlen := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	end := off + rdlen
+	if end > len(b) {
+		return rr, 0, errDNSFormat
+	}
+
+	switch rr.typ {
+	case dnsTypeSRV:
+		if rdlen < 7 {
+			return rr, 0, errDNSFormat
+		}
+		rr.priority = binary.BigEndian.Uint16(b[off:])
+		rr.weight = binary.BigEndian.Uint16(b[off+2:])
+		rr.port = binary.BigEndian.Uint16(b[off+4:])
+		if rr.target, _, err = readDNSName(b, off+6); err != nil {
+			return rr, 0, err
+		}
+	case dnsTypeA, dnsTypeAAAA:
+		if rdlen != net.IPv4len && rdlen != net.IPv6len {
+			return rr, 0, errDNSFormat
+		}
+		rr.ip = net.IP(append([]byte(nil), b[off:end]...))
+	}
+	return rr, end, nil
+}
+
+// readDNSName decodes the possibly-compressed name at b[off:],
+// returning it in dotted form with a trailing dot, and the offset of
+// the first byte after the name.
+func readDNSName(b []byte, off int) (string, int, error) {
+	var name strings.Builder
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errDNSFormat
+		}
+		c := int(b[off])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				if next < 0 {
+					next = off + 1
+				}
+				if name.Len() == 0 {
+					name.WriteByte('.')
+				}
+				return name.String(), next, nil
+			}
+			if off+1+c > len(b) {
+				return "", 0, errDNSFormat
+			}
+			name.Write(b[off+1 : off+1+c])
+			name.WriteByte('.')
+			off += 1 + c
+		case 0xc0:
+			if off+2 > len(b) {
+				return "", 0, errDNSFormat
+			}
+			// Guard against pointer loops; a legitimate
+			// message cannot need more jumps than it has
+			// bytes.
+			if jumps++; jumps > len(b) {
+				return "", 0, errDNSFormat
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		default:
+			return "", 0, errDNSFormat
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package directory
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// readHostsFile parses a hosts-style directory file.  Each line
+// holds an address followed by one or more IDs reachable at that
+// address:
+//
+//	# address        id [id ...]
+//	10.0.0.5:1986    lynch
+//	[::1]:4586       gray lamport
+//	tcp6:[::1]:5486  postel
+//	unix:/tmp/x.sock mills
+//
+// Text following a '#' is a comment.  An ID listed on several lines
+// has several addresses, in file order.
+func readHostsFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseHosts(f, path)
+}
+
+// parseHosts parses hosts-style data from r, using name in error
+// messages.
+func parseHosts(r io.Reader, name string) (map[string][]string, error) {
+	hosts := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: no IDs for address %s", name, lineno, fields[0])
+		}
+		if err := checkAddress(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, lineno, err)
+		}
+		for _, id := range fields[1:] {
+			hosts[id] = append(hosts[id], fields[0])
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// checkAddress returns an error if addr is not a valid directory
+// address.  As described for Entry, an address may be prefixed with
+// a network name and a colon: "tcp", "tcp4", or "tcp6" before a
+// host:port, or "unix" before a socket path.
+func checkAddress(addr string) error {
+	if i := strings.IndexByte(addr, ':'); i > 0 {
+		switch addr[:i] {
+		case "unix":
+			if addr[i+1:] == "" {
+				return fmt.Errorf("address %s has no socket path", addr)
+			}
+			return nil
+		case "tcp", "tcp4", "tcp6":
+			addr = addr[i+1:]
+		}
+	}
+	_, _, err := net.SplitHostPort(addr)
+	return err
+}
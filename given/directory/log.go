@@ -0,0 +1,36 @@
+package directory
+
+import (
+	"sync"
+
+	"cse586.messageservice/given/logging"
+)
+
+// defaultLog receives the events of the default directory.  It is
+// guarded by defaultLogMu.
+var (
+	defaultLogMu sync.Mutex
+	defaultLog   logging.Logger = logging.Discard
+)
+
+// SetLogger sets the Logger that receives events from the default
+// directory: changes to entries at info level, failed registrations
+// at warn level, and lookups of unknown IDs at debug level.  A nil l
+// discards them, as is the case until SetLogger is called.
+func SetLogger(l logging.Logger) {
+	defaultLogMu.Lock()
+	defer defaultLogMu.Unlock()
+	defaultLog = logging.OrDiscard(l)
+}
+
+// defaultLogger returns the Logger set by SetLogger.
+func defaultLogger() logging.Logger {
+	defaultLogMu.Lock()
+	defer defaultLogMu.Unlock()
+	return defaultLog
+}
+
+// logEvent logs a change to a directory entry.
+func logEvent(l logging.Logger, ev Event) {
+	l.Info("directory entry "+ev.Type.String(), "id", ev.Entry.ID, "addrs", ev.Entry.Addresses)
+}
@@ -0,0 +1,178 @@
+package directory
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"cse586.messageservice/given/logging"
+)
+
+// Static is an in-memory Directory holding a fixed set of entries
+// that can be edited at runtime.  It does not share any state with
+// the default directory, which makes it useful for tests that need
+// addresses of their own choosing.
+type Static struct {
+	mu       sync.Mutex
+	entries  map[string]*dirEntry
+	watchers watcherSet
+	log      logging.Logger
+}
+
+// NewStatic creates a Static directory from a map of IDs to
+// addresses.  None of the IDs are registered.
+func NewStatic(addrs map[string]string) *Static {
+	d := &Static{
+		entries: make(map[string]*dirEntry, len(addrs)),
+	}
+	for id, addr := range addrs {
+		d.entries[id] = &dirEntry{id, []string{addr}, false}
+	}
+	return d
+}
+
+// SetLogger sets the Logger that receives the directory's events, as
+// for the package-level SetLogger.  A nil l discards them.
+func (d *Static) SetLogger(l logging.Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = l
+}
+
+// Register implements Directory.  An empty id registers the first
+// unregistered ID in lexical order.
+func (d *Static) Register(id string) (_ string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer func() {
+		if err != nil {
+			d.logger().Warn("directory registration failed", "id", id, "error", err)
+		}
+	}()
+
+	if id == "" {
+		for _, candidate := range d.sortedIDs() {
+			if !d.entries[candidate].inUse {
+				id = candidate
+				break
+			}
+		}
+		if id == "" {
+			return "", errors.New("No available IDs")
+		}
+	}
+	entry, ok := d.entries[id]
+	if !ok {
+		return "", NoSuchID(id)
+	}
+	if entry.inUse {
+		return "", errors.New("Already registered")
+	}
+	entry.inUse = true
+	d.notify(Registered, entry)
+	return id, nil
+}
+
+// Unregister releases a registered ID.  Unknown or unregistered IDs
+// are ignored.
+func (d *Static) Unregister(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.entries[id]; ok && entry.inUse {
+		entry.inUse = false
+		d.notify(Unregistered, entry)
+	}
+}
+
+// Lookup implements Directory.
+func (d *Static) Lookup(id string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[id]
+	if !ok {
+		d.logger().Debug("directory lookup of unknown ID", "id", id)
+		return "", false
+	}
+	return entry.addresses[0], true
+}
+
+// LookupAll implements Directory.
+func (d *Static) LookupAll(id string) ([]string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[id]
+	if !ok {
+		d.logger().Debug("directory lookup of unknown ID", "id", id)
+		return nil, false
+	}
+	return append([]string(nil), entry.addresses...), true
+}
+
+// List implements Directory.  Entries are sorted by ID.
+func (d *Static) List() []Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries := make([]Entry, 0, len(d.entries))
+	for _, id := range d.sortedIDs() {
+		entries = append(entries, d.entries[id].public())
+	}
+	return entries
+}
+
+// Set adds id to the directory or replaces its addresses, which are
+// given in order of preference.  The registration state of an
+// existing entry is preserved.  Set panics if no address is given.
+func (d *Static) Set(id string, addresses ...string) {
+	if len(addresses) == 0 {
+		panic("directory: Set with no addresses")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[id]
+	if !ok {
+		entry = &dirEntry{id: id}
+		d.entries[id] = entry
+	}
+	entry.addresses = append([]string(nil), addresses...)
+	d.notify(Updated, entry)
+}
+
+// Remove deletes id from the directory.
+func (d *Static) Remove(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.entries[id]; ok {
+		delete(d.entries, id)
+		d.notify(Removed, entry)
+	}
+}
+
+// Watch implements Directory.
+func (d *Static) Watch() (<-chan Event, func()) {
+	return d.watchers.watch()
+}
+
+// notify reports a change to entry to every watcher.  The caller
+// must hold d.mu.
+func (d *Static) notify(t EventType, entry *dirEntry) {
+	ev := Event{t, entry.public()}
+	logEvent(d.logger(), ev)
+	d.watchers.notify(ev.Type, ev.Entry)
+}
+
+// logger returns the Logger set by SetLogger.  The caller must hold
+// d.mu.
+func (d *Static) logger() logging.Logger {
+	return logging.OrDiscard(d.log)
+}
+
+// sortedIDs returns the IDs in the directory in lexical order.  The
+// caller must hold d.mu.
+func (d *Static) sortedIDs() []string {
+	ids := make([]string, 0, len(d.entries))
+	for id := range d.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
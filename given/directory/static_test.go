@@ -0,0 +1,84 @@
+package directory
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cse586.messageservice/given/logging"
+)
+
+// TestStaticDirectory exercises registration, lookup, and edits on a
+// Static directory.
+func TestStaticDirectory(t *testing.T) {
+	var d Directory = NewStatic(map[string]string{
+		"a": "localhost:1",
+		"b": "localhost:2",
+	})
+
+	id, err := d.Register("")
+	if err != nil || id != "a" {
+		t.Errorf("Register(\"\") = %q, %v", id, err)
+	}
+	if _, err := d.Register("a"); err == nil {
+		t.Error("Second registration of registered ID succeeded")
+	}
+	if _, err := d.Register("c"); err == nil {
+		t.Error("Registration of invalid ID succeeded")
+	} else if _, ok := err.(NoSuchID); !ok {
+		t.Error("Registration of invalid ID returned invalid error")
+	}
+	if addr, ok := d.Lookup("b"); !ok || addr != "localhost:2" {
+		t.Errorf("Lookup(b) = %q, %v", addr, ok)
+	}
+
+	events, cancel := d.Watch()
+	s := d.(*Static)
+	s.Set("b", "localhost:3")
+	s.Remove("a")
+	cancel()
+
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 2 || got[0].Type != Updated || got[0].Entry.Address != "localhost:3" ||
+		got[1].Type != Removed || got[1].Entry.ID != "a" {
+		t.Errorf("Unexpected events %+v", got)
+	}
+	if entries := d.List(); len(entries) != 1 || entries[0].ID != "b" {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	s.Set("b", "localhost:4", "localhost:5")
+	if addrs, ok := d.LookupAll("b"); !ok || len(addrs) != 2 || addrs[1] != "localhost:5" {
+		t.Errorf("LookupAll(b) = %v, %v", addrs, ok)
+	}
+	if addr, _ := d.Lookup("b"); addr != "localhost:4" {
+		t.Errorf("Lookup(b) = %q", addr)
+	}
+}
+
+// TestStaticLogging ensures that a Static directory logs changes,
+// failed registrations, and lookups of unknown IDs.
+func TestStaticLogging(t *testing.T) {
+	var buf bytes.Buffer
+	d := NewStatic(map[string]string{"a": "localhost:1"})
+	d.SetLogger(logging.New(&buf, logging.LevelDebug))
+
+	d.Register("a")
+	d.Register("a")
+	d.Lookup("b")
+	d.Set("b", "localhost:2")
+
+	for _, want := range []string{
+		`level=INFO msg="directory entry registered" id=a addrs=[localhost:1]`,
+		`level=WARN msg="directory registration failed" id=a error="Already registered"`,
+		`level=DEBUG msg="directory lookup of unknown ID" id=b`,
+		`level=INFO msg="directory entry updated" id=b addrs=[localhost:2]`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Log does not contain %s:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package directory
+
+import "sync"
+
+// watcherSet is the set of channels returned by Watch for the
+// directory backends that are protected by a mutex rather than owned
+// by a goroutine.
+type watcherSet struct {
+	mu  sync.Mutex
+	set map[chan Event]struct{}
+}
+
+// watch adds a new watcher and returns it along with its cancel
+// function, as required by Directory.Watch.
+func (ws *watcherSet) watch() (<-chan Event, func()) {
+	w := make(chan Event, watchBuffer)
+	ws.mu.Lock()
+	if ws.set == nil {
+		ws.set = make(map[chan Event]struct{})
+	}
+	ws.set[w] = struct{}{}
+	ws.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			ws.mu.Lock()
+			delete(ws.set, w)
+			close(w)
+			ws.mu.Unlock()
+		})
+	}
+	return w, cancel
+}
+
+// notify sends an event to every watcher without blocking.
+func (ws *watcherSet) notify(t EventType, entry Entry) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ev := Event{t, entry}
+	for w := range ws.set {
+		select {
+		case w <- ev:
+		default:
+		}
+	}
+}
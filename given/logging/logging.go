@@ -0,0 +1,217 @@
+// The logging package defines the structured logging interface
+// accepted by the message service, the directory, and the detector.
+//
+// Logger has the method set of *slog.Logger from the standard
+// library's log/slog package, so a *slog.Logger may be used wherever
+// a Logger is accepted once the module moves to a Go release that
+// provides it.  Until then, New returns a Logger that writes lines in
+// the format of slog's TextHandler.
+//
+// Each logging call takes a message and a list of alternating keys
+// and values, as in
+//
+//	logger.Info("connected", "peer", "lynch", "addr", "localhost:1986")
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Logger records events with a message and key-value attributes.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Level is the importance of an event.  Its values are those of
+// slog.Level.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ErrUnknownLevel is returned by ParseLevel for an unrecognized
+// name.
+var ErrUnknownLevel = errors.New("unknown log level")
+
+// ParseLevel returns the level named s, which is one of "debug",
+// "info", "warn", or "error" in any case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("%w %q", ErrUnknownLevel, s)
+}
+
+// Set implements flag.Value, so that a Level may be given on the
+// command line with flag.Var.
+func (l *Level) Set(s string) error {
+	level, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// Discard is a Logger that records nothing.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Debug(string, ...any) {}
+func (discard) Info(string, ...any)  {}
+func (discard) Warn(string, ...any)  {}
+func (discard) Error(string, ...any) {}
+
+// OrDiscard returns l, or Discard if l is nil.
+func OrDiscard(l Logger) Logger {
+	if l == nil {
+		return Discard
+	}
+	return l
+}
+
+// With returns a Logger that adds args to the attributes of every
+// event logged through it by l.
+func With(l Logger, args ...any) Logger {
+	if len(args) == 0 {
+		return l
+	}
+	if w, ok := l.(*with); ok {
+		return &with{w.l, append(append([]any(nil), w.args...), args...)}
+	}
+	return &with{l, args}
+}
+
+type with struct {
+	l    Logger
+	args []any
+}
+
+func (w *with) Debug(msg string, args ...any) { w.l.Debug(msg, w.join(args)...) }
+func (w *with) Info(msg string, args ...any)  { w.l.Info(msg, w.join(args)...) }
+func (w *with) Warn(msg string, args ...any)  { w.l.Warn(msg, w.join(args)...) }
+func (w *with) Error(msg string, args ...any) { w.l.Error(msg, w.join(args)...) }
+
+func (w *with) join(args []any) []any {
+	return append(append([]any(nil), w.args...), args...)
+}
+
+// TextLogger writes events at or above a minimum level as lines of
+// key=value pairs, such as
+//
+//	time=2021-09-01T12:00:00.000Z level=WARN msg="send failed" peer=lynch
+type TextLogger struct {
+	level Level
+
+	mu sync.Mutex
+	w  io.Writer
+	// now is the clock used for the time attribute; tests
+	// replace it.
+	now func() time.Time
+}
+
+// New returns a TextLogger that writes events at level or above to w.
+func New(w io.Writer, level Level) *TextLogger {
+	return &TextLogger{level: level, w: w, now: time.Now}
+}
+
+func (t *TextLogger) Debug(msg string, args ...any) { t.log(LevelDebug, msg, args) }
+func (t *TextLogger) Info(msg string, args ...any)  { t.log(LevelInfo, msg, args) }
+func (t *TextLogger) Warn(msg string, args ...any)  { t.log(LevelWarn, msg, args) }
+func (t *TextLogger) Error(msg string, args ...any) { t.log(LevelError, msg, args) }
+
+// Enabled reports whether events at level are written.
+func (t *TextLogger) Enabled(level Level) bool {
+	return level >= t.level
+}
+
+func (t *TextLogger) log(level Level, msg string, args []any) {
+	if !t.Enabled(level) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(t.now().Format("2006-01-02T15:04:05.000Z07:00"))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(quote(msg))
+	for len(args) > 0 {
+		key := "!BADKEY"
+		if s, ok := args[0].(string); ok && len(args) > 1 {
+			key, args = s, args[1:]
+		}
+		b.WriteByte(' ')
+		b.WriteString(quote(key))
+		b.WriteByte('=')
+		b.WriteString(quote(format(args[0])))
+		args = args[1:]
+	}
+	b.WriteByte('\n')
+
+	t.mu.Lock()
+	io.WriteString(t.w, b.String())
+	t.mu.Unlock()
+}
+
+// format returns the text of an attribute value.
+func format(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// quote quotes s if it would otherwise be ambiguous, as slog does.
+func quote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTextLogger checks the filtering and formatting of events.
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo)
+	l.now = func() time.Time { return time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC) }
+
+	l.Debug("hidden")
+	With(With(l, "service", "lynch"), "peer", "mills").Warn("send failed", "error", errors.New("no route"), "n", 3)
+	l.Info("odd", "key", `a "b"`, "dangling")
+
+	want := `time=2021-09-01T12:00:00.000Z level=WARN msg="send failed" service=lynch peer=mills error="no route" n=3
+time=2021-09-01T12:00:00.000Z level=INFO msg=odd key="a \"b\"" !BADKEY=dangling
+`
+	if buf.String() != want {
+		t.Errorf("Logged\n%s\nexpected\n%s", buf.String(), want)
+	}
+
+	var level Level
+	if err := level.Set("WARN"); err != nil || level != LevelWarn {
+		t.Errorf("Set(WARN) = %v, %v", level, err)
+	}
+	if _, err := ParseLevel("loud"); !errors.Is(err, ErrUnknownLevel) {
+		t.Errorf("ParseLevel(loud) returned %v", err)
+	}
+	OrDiscard(nil).Error("discarded")
+}
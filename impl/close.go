@@ -0,0 +1,132 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultCloseTimeout is the close timeout used when
+// Options.CloseTimeout is zero.
+const DefaultCloseTimeout = 5 * time.Second
+
+// errClosed is returned by Send after the service has been closed.
+var errClosed = errors.New("message service is closed")
+
+// Close shuts the service down.  It stops accepting connections,
+// closes every inbound and outbound connection, and waits for the
+// goroutines handling them to finish before closing the Receiver
+// channel.  Messages already on the channel are discarded unless
+// Options.DrainOnClose is set.
+//
+// If the handlers do not finish within the close timeout, Close
+// returns an error and the Receiver channel is closed later, when
+// they do.  Calling Close more than once returns the result of the
+// first call.
+func (ms *messageService) Close() error {
+	ms.closeOnce.Do(func() {
+		ms.closeErr = ms.shutdown()
+	})
+	return ms.closeErr
+}
+
+// shutdown does the work of Close.
+func (ms *messageService) shutdown() error {
+	ms.mu.Lock()
+	ms.closed = true
+	conns := make([]net.Conn, 0, len(ms.conns))
+	for conn := range ms.conns {
+		conns = append(conns, conn)
+	}
+	ms.mu.Unlock()
+	close(ms.done)
+
+	var err error
+	for _, listener := range ms.listeners {
+		if lerr := listener.Close(); err == nil {
+			err = lerr
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	ms.closePool()
+	ms.lookups.close()
+	ms.metrics.close()
+
+	finished := make(chan struct{})
+	go func() {
+		ms.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		ms.closeReceiver()
+	case <-time.After(ms.opts.CloseTimeout):
+		go func() {
+			<-finished
+			ms.closeReceiver()
+		}()
+		if err == nil {
+			err = fmt.Errorf("close timed out after %v with handlers still running", ms.opts.CloseTimeout)
+		}
+	}
+	return err
+}
+
+// closeReceiver closes the Receiver and Errors channels, first
+// discarding any queued messages unless they are to be drained by the
+// application.  No handler may be running.
+func (ms *messageService) closeReceiver() {
+	close(ms.errors)
+	for !ms.opts.DrainOnClose {
+		select {
+		case <-ms.receiver:
+			continue
+		default:
+		}
+		break
+	}
+	close(ms.receiver)
+}
+
+// begin registers a goroutine that delivers messages or uses a
+// connection, so that Close can wait for it.  It returns false if the
+// service is closed, in which case the goroutine must not proceed.
+// Every successful call must be matched by a call to end.
+func (ms *messageService) begin() bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.closed {
+		return false
+	}
+	ms.wg.Add(1)
+	return true
+}
+
+// end marks the completion of a goroutine registered with begin.
+func (ms *messageService) end() {
+	ms.wg.Done()
+}
+
+// track is like begin, but also records conn so that Close can close
+// it.  Every successful call must be matched by a call to untrack.
+func (ms *messageService) track(conn net.Conn) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.closed {
+		return false
+	}
+	ms.conns[conn] = struct{}{}
+	ms.wg.Add(1)
+	return true
+}
+
+// untrack forgets a connection recorded by track.
+func (ms *messageService) untrack(conn net.Conn) {
+	ms.mu.Lock()
+	delete(ms.conns, conn)
+	ms.mu.Unlock()
+	ms.wg.Done()
+}
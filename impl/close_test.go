@@ -0,0 +1,150 @@
+package impl
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestCloseIdempotent ensures that Close can be called repeatedly
+// and that Send fails afterwards.
+func TestCloseIdempotent(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t)})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err := alice.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := alice.Close(); err != nil {
+		t.Errorf("Second Close failed: %v", err)
+	}
+	if err := alice.Send("alice", nil); err == nil {
+		t.Error("Send after Close succeeded")
+	}
+	if _, ok := <-alice.Receiver(); ok {
+		t.Error("Receiver was not closed")
+	}
+}
+
+// TestCloseWhileDelivering closes a service while senders are
+// blocked on its full receive queue, which must neither panic nor
+// hang.
+func TestCloseWhileDelivering(t *testing.T) {
+	alice, bob := newQueuePair(t, Block)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			alice.Send("bob", []byte(fmt.Sprint(i)))
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := bob.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+	for range bob.Receiver() {
+		t.Error("Queued message was not discarded")
+	}
+	wg.Wait()
+}
+
+// TestCloseConcurrentSends closes both ends of a conversation while
+// many sends are in progress.
+func TestCloseConcurrentSends(t *testing.T) {
+	alice, bob := newQueuePair(t, DropOldest)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				alice.Send("bob", []byte("x"))
+			}
+		}()
+	}
+	go func() {
+		for range bob.Receiver() {
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	bob.Close()
+	alice.Close()
+	wg.Wait()
+}
+
+// TestCloseAbortsOutbound ensures that Close interrupts a Send that
+// is waiting for its recipient.
+func TestCloseAbortsOutbound(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{
+		"alice": impltest.FreeAddr(t),
+		"bob":   impltest.FreeAddr(t),
+	})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, ReplyTimeout: time.Hour})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir, ReceiveBuffer: -1})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	sent := make(chan error)
+	go func() { sent <- alice.Send("bob", []byte("stuck")) }()
+	time.Sleep(50 * time.Millisecond)
+	alice.Close()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Error("Send was not interrupted by Close")
+	}
+}
+
+// TestDrainOnClose ensures that queued messages remain readable
+// after Close when draining is enabled.
+func TestDrainOnClose(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{
+		"alice": impltest.FreeAddr(t),
+		"bob":   impltest.FreeAddr(t),
+	})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir, DrainOnClose: true})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := alice.Send("bob", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	bob.Close()
+	n := 0
+	for rmsg := range bob.Receiver() {
+		if string(rmsg.Data) != fmt.Sprint(n) {
+			t.Errorf("Received %q, expected %d", rmsg.Data, n)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("Drained %d messages, expected 3", n)
+	}
+}
@@ -0,0 +1,94 @@
+package impl
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+
+	"cse586.messageservice/api"
+)
+
+// DefaultCompressThreshold is the compression threshold used when
+// Options.CompressThreshold is zero.  Smaller data rarely shrinks
+// enough to be worth the effort.
+const DefaultCompressThreshold = 1024
+
+// DefaultMaxDecompressedLen is the limit on decompressed data used
+// when Options.MaxDecompressedLen is zero.
+const DefaultMaxDecompressedLen = 16 << 20
+
+// compress compresses the data of msg in place if compression is
+// enabled, the data is long enough, and compressing it makes it
+// shorter.  The limit of api.MaxMessageLen applies to the result.
+func (ms *messageService) compress(msg *api.Message) error {
+	if ms.opts.Compression == api.Compression_NONE || len(msg.Data) < ms.opts.CompressThreshold {
+		return nil
+	}
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch ms.opts.Compression {
+	case api.Compression_GZIP:
+		w = gzip.NewWriter(&buf)
+	case api.Compression_DEFLATE:
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return fmt.Errorf("unsupported compression %v", ms.opts.Compression)
+	}
+	if _, err := w.Write(msg.Data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if buf.Len() < len(msg.Data) {
+		msg.Data = buf.Bytes()
+		msg.Compression = ms.opts.Compression
+	}
+	return nil
+}
+
+// decompress replaces the data of msg with its decompressed form.
+func decompress(msg *api.Message, limit int) error {
+	var r io.Reader
+	switch msg.Compression {
+	case api.Compression_NONE:
+		return nil
+	case api.Compression_GZIP:
+		zr, err := gzip.NewReader(bytes.NewReader(msg.Data))
+		if err != nil {
+			return err
+		}
+		r = zr
+	case api.Compression_DEFLATE:
+		r = flate.NewReader(bytes.NewReader(msg.Data))
+	default:
+		return fmt.Errorf("unsupported compression %v", msg.Compression)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > limit {
+		return fmt.Errorf("decompressed data exceeds %d bytes", limit)
+	}
+	msg.Data = data
+	msg.Compression = api.Compression_NONE
+	return nil
+}
+
+// deliverLocal decompresses msg and delivers it to the receive
+// queue.  A message that cannot be decompressed is reported and
+// refused.
+func (ms *messageService) deliverLocal(msg *api.Message, remote net.Addr) error {
+	if err := decompress(msg, ms.opts.MaxDecompressedLen); err != nil {
+		ms.reportError(DecompressFailed, remote, err)
+		return err
+	}
+	span := ms.startReceiveSpan(msg)
+	err := ms.deliver(msg)
+	span.Finish(err)
+	return err
+}
@@ -0,0 +1,93 @@
+package impl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestCompressedSend sends data too long for a message uncompressed
+// with each algorithm and ensures that it arrives intact.
+func TestCompressedSend(t *testing.T) {
+	data := bytes.Repeat([]byte("state transfer "), 10000)
+	for _, c := range []api.Compression{api.Compression_NONE, api.Compression_GZIP, api.Compression_DEFLATE} {
+		t.Run(c.String(), func(t *testing.T) {
+			dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t)})
+			alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, Compression: c})
+			if err != nil {
+				t.Fatalf("Could not create service: %v", err)
+			}
+			defer alice.Close()
+			bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+			if err != nil {
+				t.Fatalf("Could not create service: %v", err)
+			}
+			defer bob.Close()
+
+			err = alice.Send("bob", data)
+			if c == api.Compression_NONE {
+				var tooLong *api.MessageTooLong
+				if !errors.As(err, &tooLong) {
+					t.Errorf("Uncompressed Send returned %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			if rmsg := <-bob.Receiver(); !bytes.Equal(rmsg.Data, data) || rmsg.Compression != api.Compression_NONE {
+				t.Errorf("Received %d bytes with compression %v", len(rmsg.Data), rmsg.Compression)
+			}
+		})
+	}
+}
+
+// TestCompressThreshold ensures that short or incompressible data
+// is sent as is.
+func TestCompressThreshold(t *testing.T) {
+	ms := &messageService{opts: Options{Compression: api.Compression_GZIP}.withDefaults()}
+	for _, data := range [][]byte{
+		bytes.Repeat([]byte("a"), DefaultCompressThreshold-1),
+		[]byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff"),
+	} {
+		msg := &api.Message{Data: data}
+		if err := ms.compress(msg); err != nil || msg.Compression != api.Compression_NONE || !bytes.Equal(msg.Data, data) {
+			t.Errorf("%d bytes were compressed: %v, %v", len(data), msg.Compression, err)
+		}
+	}
+}
+
+// TestDecompressLimit ensures that data decompressing to more than
+// the receiver's limit is refused.
+func TestDecompressLimit(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t)})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, Compression: api.Compression_GZIP})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir, MaxDecompressedLen: 4096})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	// The first message to bob negotiates compression, so it is
+	// sent without.
+	if err := alice.Send("bob", make([]byte, 8192)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	<-bob.Receiver()
+	var rej *api.MessageRejected
+	if err := alice.Send("bob", make([]byte, 8192)); !errors.As(err, &rej) {
+		t.Errorf("Send returned %v", err)
+	}
+	var rerr *ReceiveError
+	if err := <-bob.Errors(); !errors.As(err, &rerr) || rerr.Kind != DecompressFailed {
+		t.Errorf("Expected DecompressFailed, got %v", err)
+	}
+}
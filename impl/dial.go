@@ -0,0 +1,145 @@
+package impl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"cse586.messageservice/given/directory"
+)
+
+// DefaultFallbackDelay is the delay used when Options.FallbackDelay
+// is zero.  It follows the recommendation of RFC 8305.
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// dialTimeout bounds a single connection attempt.
+const dialTimeout = 5 * time.Second
+
+// splitAddress separates a directory address into the network to
+// use for it and the address proper.  An address may be prefixed
+// with a network name and a colon; otherwise it is a TCP address.
+// For example, "unix:/tmp/lynch.sock" names a Unix domain socket.
+func splitAddress(addr string) (network, address string) {
+	if i := strings.IndexByte(addr, ':'); i > 0 {
+		switch addr[:i] {
+		case "tcp", "tcp4", "tcp6", "unix":
+			return addr[:i], addr[i+1:]
+		}
+	}
+	return "tcp", addr
+}
+
+// dial connects to recipient, returning the connection and the
+// address it was made to.  Its addresses are tried with dialAny,
+// starting with the one that worked most recently; if none of them
+// work, the recipient is looked up again in case it has moved.
+func (ms *messageService) dial(recipient string) (net.Conn, string, error) {
+	addrs, ok := ms.lookups.lookup(recipient)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnknownRecipient, recipient)
+	}
+	start := time.Now()
+	conn, addr, err := dialAny(ms.preferLast(recipient, addrs), ms.opts.FallbackDelay)
+	if err != nil {
+		ms.lookups.invalidate(recipient)
+		if fresh, ok := ms.lookups.lookup(recipient); ok && !directory.EqualAddrs(fresh, addrs) {
+			conn, addr, err = dialAny(ms.preferLast(recipient, fresh), ms.opts.FallbackDelay)
+		}
+	}
+	ms.metrics.dialed(start)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect: %w", err)
+	}
+
+	ms.mu.Lock()
+	ms.lastAddr[recipient] = addr
+	ms.mu.Unlock()
+	ms.log.Debug("connected", "peer", recipient, "addr", addr)
+	return conn, addr, nil
+}
+
+// preferLast returns a copy of addrs with the address that last
+// worked for recipient, if any, moved to the front.
+func (ms *messageService) preferLast(recipient string, addrs []string) []string {
+	ms.mu.Lock()
+	last, ok := ms.lastAddr[recipient]
+	ms.mu.Unlock()
+
+	ordered := make([]string, 0, len(addrs))
+	if ok {
+		for _, addr := range addrs {
+			if addr == last {
+				ordered = append(ordered, addr)
+				break
+			}
+		}
+	}
+	for _, addr := range addrs {
+		if len(ordered) == 0 || addr != ordered[0] {
+			ordered = append(ordered, addr)
+		}
+	}
+	return ordered
+}
+
+// dialAny connects to one of addrs, in the style of Happy Eyeballs
+// (RFC 8305): addresses are attempted in order, and each attempt
+// starts when the previous one fails or has not succeeded within
+// delay.  The first connection established is returned along with
+// its address, and any that complete later are closed.  If every
+// attempt fails, the first error is returned.
+func dialAny(addrs []string, delay time.Duration) (net.Conn, string, error) {
+	type result struct {
+		conn net.Conn
+		addr string
+		err  error
+	}
+	// results is buffered so that losing attempts never block.
+	results := make(chan result, len(addrs))
+
+	next, pending := 0, 0
+	var fallback <-chan time.Time
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			network, address := splitAddress(addr)
+			conn, err := net.DialTimeout(network, address, dialTimeout)
+			results <- result{conn, addr, err}
+		}()
+		fallback = nil
+		if next < len(addrs) {
+			fallback = time.After(delay)
+		}
+	}
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.err == nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.addr, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-fallback:
+			start()
+		}
+	}
+	return nil, "", firstErr
+}
@@ -0,0 +1,107 @@
+package impl
+
+import (
+	"testing"
+	"time"
+
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestSplitAddress checks the parsing of network prefixes.
+func TestSplitAddress(t *testing.T) {
+	for addr, want := range map[string][2]string{
+		"localhost:1986":   {"tcp", "localhost:1986"},
+		"tcp6:[::1]:1986":  {"tcp6", "[::1]:1986"},
+		"tcp4:127.0.0.1:1": {"tcp4", "127.0.0.1:1"},
+		"[::1]:1986":       {"tcp", "[::1]:1986"},
+		"unix:/tmp/x.sock": {"unix", "/tmp/x.sock"},
+	} {
+		if network, address := splitAddress(addr); network != want[0] || address != want[1] {
+			t.Errorf("splitAddress(%q) = %q, %q", addr, network, address)
+		}
+	}
+}
+
+// TestMultipleAddresses gives a recipient two addresses, the first
+// of which does not work, and ensures that Send fails over to the
+// second and remembers it.
+func TestMultipleAddresses(t *testing.T) {
+	dead := impltest.FreeAddr(t)
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t)})
+	dir.Set("bob", dead, "tcp4:"+impltest.FreeAddr(t))
+
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, FallbackDelay: time.Hour})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+
+	// bob cannot listen on both addresses, so listen on the
+	// working one alone.
+	addrs, _ := dir.LookupAll("bob")
+	bobDir := directory.NewStatic(map[string]string{"bob": addrs[1]})
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: bobDir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := alice.Send("bob", []byte("hello")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		<-bob.Receiver()
+	}
+	if order := alice.(*messageService).preferLast("bob", addrs); order[0] != addrs[1] {
+		t.Errorf("Working address was not remembered: %v", order)
+	}
+}
+
+// TestListenAllAddresses ensures that a service with two addresses
+// receives messages sent to either of them.
+func TestListenAllAddresses(t *testing.T) {
+	first, second := impltest.FreeAddr(t), impltest.FreeAddr(t)
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t)})
+	dir.Set("bob", first, second)
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	for _, addr := range []string{first, second} {
+		conn, _, err := dialAny([]string{addr}, 0)
+		if err != nil {
+			t.Fatalf("Could not connect to %s: %v", addr, err)
+		}
+		conn.Write(staticMsg[:])
+		conn.Close()
+		if rmsg := <-bob.Receiver(); rmsg.Sender != staticMsgSender {
+			t.Errorf("Unexpected message %v", rmsg)
+		}
+	}
+}
+
+// TestDialAnyFallback ensures that a slow or failing first address
+// does not prevent a connection to a later one.
+func TestDialAnyFallback(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"bob": impltest.FreeAddr(t)})
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+	good, _ := dir.Lookup("bob")
+
+	// 192.0.2.0/24 is reserved for documentation, so a
+	// connection attempt to it is expected to hang or fail.
+	conn, addr, err := dialAny([]string{"192.0.2.1:9", impltest.FreeAddr(t), good}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("dialAny failed: %v", err)
+	}
+	conn.Close()
+	if addr != good {
+		t.Errorf("dialAny connected to %s", addr)
+	}
+}
@@ -0,0 +1,65 @@
+// Package envelope marks the messages that carry protocol data for
+// the packages layered on a message service, such as rpc calls and
+// pubsub publications.  An envelope is a message whose data is a
+// protobuf message and whose Header header names the kind of
+// envelope, so plain messages are never mistaken for envelopes,
+// whatever their data, and layers that share a service each see only
+// their own envelopes.
+package envelope
+
+import (
+	"fmt"
+
+	"cse586.messageservice/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// Header is the message header that holds the content type of an
+// envelope.
+const Header = "content-type"
+
+// The content types of the envelopes used by this module.
+const (
+	RPC         = "application/x-messageservice-rpc"
+	Publication = "application/x-messageservice-publication"
+	Typed       = "application/x-messageservice-typed"
+)
+
+// Sender is implemented by services that can send messages with
+// headers, such as impl.Service.
+type Sender interface {
+	SendMessage(msg *api.Message) error
+}
+
+// New returns a message holding body in an envelope of contentType.
+// The caller sets its recipient.
+func New(contentType string, body proto.Message) (*api.Message, error) {
+	data, err := proto.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %v", err)
+	}
+	msg := &api.Message{Data: data}
+	msg.SetHeader(Header, contentType)
+	return msg, nil
+}
+
+// Send sends body to recipient in an envelope of contentType.
+func Send(s Sender, recipient, contentType string, body proto.Message) error {
+	msg, err := New(contentType, body)
+	if err != nil {
+		return err
+	}
+	msg.Recipient = recipient
+	return s.SendMessage(msg)
+}
+
+// Is reports whether msg is an envelope of contentType.
+func Is(msg *api.Message, contentType string) bool {
+	return msg.Header(Header) == contentType
+}
+
+// Open unmarshals the envelope in msg into body.  It returns false if
+// msg is not an envelope of contentType or cannot be unmarshaled.
+func Open(msg *api.Message, contentType string, body proto.Message) bool {
+	return Is(msg, contentType) && proto.Unmarshal(msg.Data, body) == nil
+}
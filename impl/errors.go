@@ -0,0 +1,184 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// DefaultErrorBuffer is the capacity of the Errors channel used when
+// Options.ErrorBuffer is zero.
+const DefaultErrorBuffer = 16
+
+// ErrUnknownRecipient is wrapped by the error returned by Send when
+// the directory does not know the recipient.
+var ErrUnknownRecipient = errors.New("unknown recipient ID")
+
+// ErrReplyTimeout is returned by Send when the recipient did not
+// answer a message within Options.ReplyTimeout, so that it is not
+// known whether the message was accepted.
+var ErrReplyTimeout = errors.New("no reply from recipient")
+
+// UnsupportedError is returned by Send when a message needs a
+// protocol feature that the recipient does not support, such as a
+// routed message for a recipient that does not support routing, or
+// compressed data that cannot be decompressed for a recipient that
+// does not support compression.
+type UnsupportedError struct {
+	Feature Feature
+	// Err is the error that prevented the message from being sent
+	// without the feature, if any.
+	Err error
+}
+
+func (err *UnsupportedError) Error() string {
+	if err.Err == nil {
+		return fmt.Sprintf("recipient does not support %v", err.Feature)
+	}
+	return fmt.Sprintf("recipient does not support %v: %v", err.Feature, err.Err)
+}
+
+func (err *UnsupportedError) Unwrap() error {
+	return err.Err
+}
+
+// ErrorKind classifies a ReceiveError.
+type ErrorKind int
+
+const (
+	// AcceptFailed means that accepting a connection failed.
+	AcceptFailed ErrorKind = iota
+	// ReadFailed means that a connection failed while it was
+	// being read, other than by ending in the middle of a frame.
+	ReadFailed
+	// FrameTruncated means that a connection or datagram ended
+	// in the middle of a frame.  Err is ErrTruncatedFrame.
+	FrameTruncated
+	// FrameTooLong means that a frame announced a message longer
+	// than is permitted.  Err is a *FrameTooLongError.
+	FrameTooLong
+	// UnmarshalFailed means that a frame did not hold a valid
+	// message.  Err is a *MalformedFrameError.
+	UnmarshalFailed
+	// RecipientMismatch means that a message was addressed to a
+	// recipient other than this service.
+	RecipientMismatch
+	// ForwardFailed means that a misaddressed message could not
+	// be forwarded to its recipient.
+	ForwardFailed
+	// DecompressFailed means that the data of a message could
+	// not be decompressed.
+	DecompressFailed
+	// RateLimited means that a message was refused because its
+	// sender exceeded the receive rate limit.
+	RateLimited
+	// ConnectionLimit means that a connection was closed because
+	// it exceeded Options.MaxConnections or
+	// Options.MaxFramesPerConnection.
+	ConnectionLimit
+	// Timeout means that a connection was closed because its
+	// sender was idle or slow for longer than Options.IdleTimeout
+	// or Options.ReadTimeout.
+	Timeout
+)
+
+var errorKindNames = [...]string{
+	AcceptFailed:      "accept failed",
+	ReadFailed:        "read failed",
+	FrameTruncated:    "frame truncated",
+	FrameTooLong:      "frame too long",
+	UnmarshalFailed:   "unmarshal failed",
+	RecipientMismatch: "recipient mismatch",
+	ForwardFailed:     "forward failed",
+	DecompressFailed:  "decompress failed",
+	RateLimited:       "rate limited",
+	ConnectionLimit:   "connection limit",
+	Timeout:           "timeout",
+}
+
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(errorKindNames) {
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+	return errorKindNames[k]
+}
+
+// ReceiveError reports a failure that occurred while receiving
+// messages, outside of any call made by the application.
+type ReceiveError struct {
+	Kind ErrorKind
+	// Remote is the address of the peer involved, if known.
+	Remote net.Addr
+	// Err is the underlying error.
+	Err error
+}
+
+func (err *ReceiveError) Error() string {
+	if err.Remote == nil {
+		return fmt.Sprintf("%v: %v", err.Kind, err.Err)
+	}
+	return fmt.Sprintf("%v from %v: %v", err.Kind, err.Remote, err.Err)
+}
+
+func (err *ReceiveError) Unwrap() error {
+	return err.Err
+}
+
+// MisaddressedError is the underlying error of a RecipientMismatch.
+type MisaddressedError struct {
+	Sender    string // Sender is the sender named in the message
+	Recipient string // Recipient is the recipient named in the message
+}
+
+func (err *MisaddressedError) Error() string {
+	return fmt.Sprintf("message from %q is addressed to %q", err.Sender, err.Recipient)
+}
+
+// Errors returns a channel on which asynchronous receive-side errors
+// are reported as *ReceiveError values.  Errors are discarded if the
+// channel is full.  It is closed when the service is closed.
+func (ms *messageService) Errors() <-chan error {
+	return ms.errors
+}
+
+// temporary reports whether err, the reason that a message was
+// refused, is expected to clear.
+func temporary(err error) bool {
+	var throttled *ThrottledError
+	return errors.Is(err, errQueueFull) || errors.Is(err, errClosed) || errors.As(err, &throttled)
+}
+
+// receiveErrorKind classifies an error returned by readFrame or
+// unmarshalFrame.
+func receiveErrorKind(err error) ErrorKind {
+	var tooLong *FrameTooLongError
+	var malformed *MalformedFrameError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	case errors.Is(err, ErrTruncatedFrame):
+		return FrameTruncated
+	case errors.As(err, &tooLong):
+		return FrameTooLong
+	case errors.As(err, &malformed):
+		return UnmarshalFailed
+	}
+	return ReadFailed
+}
+
+// reportError reports an asynchronous error to the error handler, if
+// one is configured, and on the Errors channel.  The caller must be
+// registered with begin or track.
+func (ms *messageService) reportError(kind ErrorKind, remote net.Addr, err error) {
+	rerr := &ReceiveError{kind, remote, err}
+	ms.metrics.receiveError(kind)
+	ms.log.Warn("receive failed", "kind", kind, "remote", remote, "error", err)
+	if ms.opts.OnError != nil {
+		ms.opts.OnError(rerr)
+	}
+	select {
+	case ms.errors <- rerr:
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package impl
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestErrorsChannel sends a misaddressed message and a malformed
+// frame and checks the errors reported on the Errors channel.
+func TestErrorsChannel(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"bob": impltest.FreeAddr(t)})
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+
+	addr, _ := dir.Lookup("bob")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write(staticMsg[:])
+	conn.Write([]byte{0, 2, 0xff, 0xff})
+	local := conn.LocalAddr().String()
+	conn.Close()
+
+	for _, want := range []ErrorKind{RecipientMismatch, UnmarshalFailed} {
+		var rerr *ReceiveError
+		if err := <-bob.Errors(); !errors.As(err, &rerr) || rerr.Kind != want {
+			t.Errorf("Expected %v, got %v", want, err)
+		} else if rerr.Remote == nil || rerr.Remote.String() != local {
+			t.Errorf("Error has remote address %v, expected %s", rerr.Remote, local)
+		}
+	}
+	var misaddressed *MisaddressedError
+	if rerr := (&ReceiveError{RecipientMismatch, nil, &MisaddressedError{"a", "b"}}); !errors.As(rerr, &misaddressed) {
+		t.Error("ReceiveError does not unwrap")
+	}
+
+	// Mismatched messages are still delivered.
+	if rmsg := <-bob.Receiver(); rmsg.Recipient != staticMsgRecipient {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+
+	bob.Close()
+	if _, ok := <-bob.Errors(); ok {
+		t.Error("Errors channel was not closed")
+	}
+}
+
+// TestUnexpectedKinds sends frames that only travel from receiver to
+// sender and ensures that they are reported rather than delivered.
+func TestUnexpectedKinds(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"bob": impltest.FreeAddr(t)})
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	addr, _ := dir.Lookup("bob")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, kind := range []api.Kind{api.Kind_ACK, api.Kind_ERROR, api.Kind_THROTTLED, api.Kind_CREDIT, api.Kind_DATA} {
+		frame, _ := marshalFrame(&api.Message{Sender: "alice", Recipient: "bob", Kind: kind, Data: []byte(kind.String())})
+		conn.Write(frame)
+	}
+	for i := 0; i < 4; i++ {
+		var malformed *MalformedFrameError
+		if err := <-bob.Errors(); !errors.As(err, &malformed) {
+			t.Errorf("Expected a malformed frame, got %v", err)
+		}
+	}
+	if rmsg := <-bob.Receiver(); rmsg.Kind != api.Kind_DATA {
+		t.Errorf("Received %v frame", rmsg.Kind)
+	}
+}
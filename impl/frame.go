@@ -0,0 +1,184 @@
+package impl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"cse586.messageservice/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// A frame is a two-byte big-endian length followed by that many
+// bytes of marshalled api.Message.  Stream transports carry any
+// number of frames back to back, and datagram transports carry
+// exactly one frame per datagram.
+
+// frameHeaderLen is the size of the length header on each frame.
+const frameHeaderLen = 2
+
+// maxFrameBody is the largest message body that fits in a frame
+// without the frame exceeding api.MaxMessageLen.
+const maxFrameBody = api.MaxMessageLen - frameHeaderLen
+
+// ErrTruncatedFrame is reported when a stream or datagram ends in
+// the middle of a frame.
+var ErrTruncatedFrame = errors.New("truncated frame")
+
+// FrameTooLongError is reported when a frame header announces a
+// message longer than this implementation accepts.  The oversized
+// message is skipped.
+type FrameTooLongError struct {
+	Len int // Len is the length announced by the header
+}
+
+func (err *FrameTooLongError) Error() string {
+	return fmt.Sprintf("frame of %d bytes exceeds limit of %d", err.Len, maxFrameBody)
+}
+
+// MalformedFrameError is reported when the body of a frame is not a
+// valid api.Message, or a datagram holds bytes beyond its frame.
+type MalformedFrameError struct {
+	Err error
+}
+
+func (err *MalformedFrameError) Error() string {
+	return fmt.Sprintf("malformed frame: %v", err.Err)
+}
+
+func (err *MalformedFrameError) Unwrap() error {
+	return err.Err
+}
+
+// unexpectedKind returns the error reported for a frame of a kind
+// that is not expected where it arrived.
+func unexpectedKind(msg *api.Message) error {
+	return &MalformedFrameError{fmt.Errorf("unexpected %v frame", msg.Kind)}
+}
+
+// marshalFrame encodes msg as a frame.  It returns
+// api.MessageTooLong if the frame would exceed api.MaxMessageLen.
+func marshalFrame(msg *api.Message) ([]byte, error) {
+	datas, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %v", err)
+	}
+	if len(datas) > maxFrameBody {
+		return nil, tooLong(len(datas))
+	}
+	startBinData := Int16ToBytes(int16(len(datas)))
+	return append(startBinData, datas...), nil
+}
+
+// checkFrame returns api.MessageTooLong if msg would not fit in a
+// frame, without encoding it.
+func checkFrame(msg *api.Message) error {
+	if n := proto.Size(msg); n > maxFrameBody {
+		return tooLong(n)
+	}
+	return nil
+}
+
+// tooLong returns the error for a message body of n bytes.
+func tooLong(n int) error {
+	return &api.MessageTooLong{
+		Msg: fmt.Sprintf("Message is %d bytes", n+frameHeaderLen),
+	}
+}
+
+// unmarshalFrame decodes a datagram holding exactly one frame.
+func unmarshalFrame(b []byte) (*api.Message, error) {
+	if len(b) < frameHeaderLen {
+		return nil, ErrTruncatedFrame
+	}
+	n := BytesToInt(b)
+	switch body := b[frameHeaderLen:]; {
+	case n > maxFrameBody:
+		return nil, &FrameTooLongError{n}
+	case n > len(body):
+		return nil, ErrTruncatedFrame
+	case n < len(body):
+		return nil, &MalformedFrameError{fmt.Errorf("%d bytes after frame", len(body)-n)}
+	}
+	return unmarshalBody(b[frameHeaderLen:])
+}
+
+// unmarshalBody decodes the body of a frame.
+func unmarshalBody(b []byte) (*api.Message, error) {
+	msg := &api.Message{}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		return nil, &MalformedFrameError{err}
+	}
+	return msg, nil
+}
+
+// frameReader reads successive frames from a stream.
+type frameReader struct {
+	r   *bufio.Reader
+	hdr [frameHeaderLen]byte
+	buf []byte
+
+	// If conn is set, the idle timeout bounds the wait for each
+	// frame to begin and the read timeout bounds the time taken
+	// to read the rest of it.  A timeout of zero or less is none.
+	conn        interface{ SetReadDeadline(time.Time) error }
+	idleTimeout time.Duration
+	readTimeout time.Duration
+}
+
+// newFrameReader creates a frameReader on r.
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: bufio.NewReader(r)}
+}
+
+// readFrame reads the next frame.  It returns io.EOF if the stream
+// ends cleanly between frames, and ErrTruncatedFrame if it ends
+// within one.  After a FrameTooLongError or MalformedFrameError the
+// offending frame has been consumed and reading may continue; after
+// any other error the stream is unusable.
+func (fr *frameReader) readFrame() (*api.Message, error) {
+	fr.setDeadline(fr.idleTimeout)
+	if n, err := io.ReadFull(fr.r, fr.hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF && n > 0 {
+			err = ErrTruncatedFrame
+		}
+		return nil, err
+	}
+
+	fr.setDeadline(fr.readTimeout)
+	n := BytesToInt(fr.hdr[:])
+	if n > maxFrameBody {
+		if _, err := fr.r.Discard(n); err != nil {
+			return nil, ErrTruncatedFrame
+		}
+		return nil, &FrameTooLongError{n}
+	}
+	if cap(fr.buf) < n {
+		fr.buf = make([]byte, n)
+	}
+	body := fr.buf[:n]
+	if _, err := io.ReadFull(fr.r, body); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedFrame
+		}
+		return nil, err
+	}
+	return unmarshalBody(body)
+}
+
+// setDeadline sets the read deadline of fr.conn to d from now.
+func (fr *frameReader) setDeadline(d time.Duration) {
+	if fr.conn != nil && d > 0 {
+		fr.conn.SetReadDeadline(time.Now().Add(d))
+	}
+}
+
+// recoverable reports whether a stream can still be read after
+// readFrame returned err.
+func recoverable(err error) bool {
+	var tooLong *FrameTooLongError
+	var malformed *MalformedFrameError
+	return errors.As(err, &tooLong) || errors.As(err, &malformed)
+}
@@ -0,0 +1,155 @@
+package impl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestReadFrameErrors checks readFrame against well-formed and
+// damaged streams.
+func TestReadFrameErrors(t *testing.T) {
+	tooLong := append([]byte{0xff, 0xff}, make([]byte, 0xffff)...)
+	for _, tc := range []struct {
+		name  string
+		input []byte
+		check func(error) bool
+	}{
+		{"empty", nil, func(err error) bool { return err == io.EOF }},
+		{"short header", staticMsg[:1], func(err error) bool { return err == ErrTruncatedFrame }},
+		{"short body", staticMsg[:10], func(err error) bool { return err == ErrTruncatedFrame }},
+		{"too long", tooLong, func(err error) bool {
+			var e *FrameTooLongError
+			return errors.As(err, &e) && e.Len == 0xffff
+		}},
+		{"malformed", []byte{0, 2, 0xff, 0xff}, func(err error) bool {
+			var e *MalformedFrameError
+			return errors.As(err, &e)
+		}},
+	} {
+		_, err := newFrameReader(bytes.NewReader(tc.input)).readFrame()
+		if !tc.check(err) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+	}
+
+	// A stream can still be read after a recoverable error.
+	stream := append(append(tooLong, 0, 2, 0xff, 0xff), staticMsg[:]...)
+	fr := newFrameReader(bytes.NewReader(stream))
+	for i := 0; i < 2; i++ {
+		if _, err := fr.readFrame(); !recoverable(err) {
+			t.Fatalf("Frame %d: expected a recoverable error, got %v", i, err)
+		}
+	}
+	if msg, err := fr.readFrame(); err != nil || msg.Sender != staticMsgSender {
+		t.Errorf("Frame after errors = %v, %v", msg, err)
+	}
+	if _, err := fr.readFrame(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+// TestStreamErrorsReported sends several frames, some of them bad,
+// over one connection and ensures that the bad ones are reported and
+// the good ones delivered.
+func TestStreamErrorsReported(t *testing.T) {
+	errs := make(chan error, 10)
+	dir := directory.NewStatic(map[string]string{staticMsgRecipient: impltest.FreeAddr(t)})
+	bob, err := NewMessageServiceWithOptions(staticMsgRecipient, Options{
+		Directory: dir,
+		OnError:   func(err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	addr, _ := dir.Lookup(staticMsgRecipient)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write(staticMsg[:])
+	conn.Write([]byte{0, 2, 0xff, 0xff})
+	conn.Write(staticMsg[:])
+	conn.Write(staticMsg[:5])
+	conn.Close()
+
+	for i := 0; i < 2; i++ {
+		if rmsg := <-bob.Receiver(); rmsg.Sender != staticMsgSender {
+			t.Errorf("Unexpected message %v", rmsg)
+		}
+	}
+	var malformed *MalformedFrameError
+	if err := <-errs; !errors.As(err, &malformed) {
+		t.Errorf("Expected a malformed frame error, got %v", err)
+	}
+	if err := <-errs; !errors.Is(err, ErrTruncatedFrame) {
+		t.Errorf("Expected a truncated frame error, got %v", err)
+	}
+}
+
+// FuzzReadFrame feeds arbitrary streams to the frame reader, which
+// must never panic, must make progress, and must return only
+// messages that survive re-encoding.
+func FuzzReadFrame(f *testing.F) {
+	f.Add(staticMsg[:])
+	f.Add(append(staticMsg[:], staticMsg[:]...))
+	f.Add(staticMsg[:7])
+	f.Add([]byte{0xff, 0xff, 0})
+	f.Add([]byte{0, 2, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, input []byte) {
+		fr := newFrameReader(bytes.NewReader(input))
+		for i := 0; i <= len(input); i++ {
+			msg, err := fr.readFrame()
+			if err != nil {
+				if !recoverable(err) {
+					return
+				}
+				continue
+			}
+			if _, err := marshalFrame(msg); err != nil {
+				t.Fatalf("Decoded message cannot be encoded: %v", err)
+			}
+		}
+		t.Fatal("readFrame returned more frames than the input has bytes")
+	})
+}
+
+// FuzzFrameRoundTrip encodes arbitrary messages and ensures that
+// both decoders recover them.
+func FuzzFrameRoundTrip(f *testing.F) {
+	f.Add(staticMsgSender, staticMsgRecipient, staticMsgText[:])
+	f.Add("", "", []byte{})
+	f.Fuzz(func(t *testing.T, sender, recipient string, data []byte) {
+		msg := &api.Message{Sender: sender, Recipient: recipient, Data: data}
+		frame, err := marshalFrame(msg)
+		if err != nil {
+			return
+		}
+		for _, decode := range []func() (*api.Message, error){
+			func() (*api.Message, error) { return unmarshalFrame(frame) },
+			func() (*api.Message, error) { return newFrameReader(bytes.NewReader(frame)).readFrame() },
+		} {
+			got, err := decode()
+			if err != nil {
+				// Strings that are not valid UTF-8 are
+				// refused by the decoder.
+				var malformed *MalformedFrameError
+				if errors.As(err, &malformed) {
+					continue
+				}
+				t.Fatalf("Decoding failed: %v", err)
+			}
+			if got.Sender != sender || got.Recipient != recipient || !bytes.Equal(got.Data, data) {
+				t.Fatalf("Round trip changed message: %v", got)
+			}
+		}
+	})
+}
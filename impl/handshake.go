@@ -0,0 +1,259 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"cse586.messageservice/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// Stream connections begin with a handshake, so that services can
+// use protocol features that older services do not understand.  The
+// sender speaks first: the first frame it sends on a connection
+// carries its protocol version and features.  A receiver that
+// understands them replies with a HANDSHAKE frame giving the
+// negotiated version and features, which are the lower version and
+// the features both ends support, and the sender uses only those
+// features from then on.  A receiver never speaks first, so it sends
+// nothing to a sender that did not announce itself.
+//
+// The sender's first frame is normally its first message, sent using
+// only the features already negotiated with the receiver's address,
+// if any.  It announces the sender even to a receiver known to be a
+// legacy service, which costs nothing and notices if the receiver has
+// been upgraded.  Legacy services speak only the original protocol of
+// one bare frame per connection.  A legacy receiver skips the fields
+// it does not know, reads the message as it always has, and closes
+// the connection without replying, so the sender learns that it is a
+// legacy service without waiting for anything.  A legacy sender's
+// first frame carries no version, so the receiver sends it nothing
+// but the error frames that the original protocol has.
+//
+// A sender sends a bare HANDSHAKE only to an address where it has
+// already found a receiver that understands one, since a legacy
+// receiver would take it for an empty message.  It does so when it
+// needs credit before it can send, because flow control is on, or
+// when the message leaves no room to announce the sender, and it
+// waits up to the handshake timeout for the reply.  Until the
+// receiver's version is known, the sender uses neither compression
+// nor credit, and sends a routed message as if the receiver supported
+// routing, so that a message can be routed through a relay the sender
+// has not sent to before.  A legacy relay takes such a message for
+// one addressed to it.  The one exception is a message that fits in a
+// frame only compressed, which cannot be sent to a legacy receiver at
+// all: the sender sends a bare HANDSHAKE first to learn whether the
+// receiver supports compression, and a legacy receiver delivers it as
+// an empty message.
+//
+// Each protocol version defines a fixed set of features.  Version 1
+// defines compression, routing and credit, granted in the HANDSHAKE
+// frame.  Version 2 adds persistent connections, with the ACK and
+// CREDIT frames that they need.
+//
+// Datagram transports have no handshake: both ends must support the
+// same features.
+
+// ProtocolVersion is the version of the protocol implemented by this
+// package.  The original protocol is version 0.
+const ProtocolVersion = 2
+
+// DefaultHandshakeTimeout is the handshake timeout used when
+// Options.HandshakeTimeout is zero.
+const DefaultHandshakeTimeout = 250 * time.Millisecond
+
+// errLegacyPeer is returned by handshake when the receiver did not
+// reply to a bare HANDSHAKE, and so took it for a message.
+var errLegacyPeer = errors.New("recipient is a legacy service")
+
+// Feature is a set of optional protocol features.
+type Feature uint64
+
+const (
+	// FeatureCompression means that compressed message data is
+	// understood.
+	FeatureCompression Feature = 1 << iota
+	// FeatureRouting means that routed messages are understood.
+	FeatureRouting
+	// FeatureCredit means that the receiver's HANDSHAKE, ACK and
+	// CREDIT frames grant flow control credit.
+	FeatureCredit
+	// FeaturePersistent means that the receiver answers each
+	// message with an ACK frame if it accepts it, so that the
+	// connection can be kept open for more.  See pool.go.
+	FeaturePersistent
+
+	// AllFeatures is the set of features implemented by this
+	// package.
+	AllFeatures = FeatureCompression | FeatureRouting | FeatureCredit | FeaturePersistent
+)
+
+// protocolFeatures lists the features defined by each protocol
+// version.
+var protocolFeatures = [...]Feature{
+	0: 0,
+	1: FeatureCompression | FeatureRouting | FeatureCredit,
+	2: FeatureCompression | FeatureRouting | FeatureCredit | FeaturePersistent,
+}
+
+var featureNames = [...]string{"compression", "routing", "credit", "persistent connections"}
+
+func (f Feature) String() string {
+	var names []string
+	for i, name := range featureNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := f &^ AllFeatures; rest != 0 || len(names) == 0 {
+		names = append(names, fmt.Sprintf("%#x", uint64(rest)))
+	}
+	return strings.Join(names, "|")
+}
+
+// versionFeatures returns the features defined by protocol version v,
+// which is at most ProtocolVersion.
+func versionFeatures(v uint32) Feature {
+	return protocolFeatures[v]
+}
+
+// features returns the features this service supports.
+func (ms *messageService) features() Feature {
+	if ms.opts.Legacy {
+		return 0
+	}
+	return AllFeatures &^ ms.opts.DisableFeatures
+}
+
+// hello returns the frame with which this service announces itself
+// on a connection that it opened.
+func (ms *messageService) hello() *api.Message {
+	return &api.Message{
+		Sender:   ms.id,
+		Kind:     api.Kind_HANDSHAKE,
+		Version:  ProtocolVersion,
+		Features: uint64(ms.features()),
+	}
+}
+
+// greet performs the receiver's side of the handshake on an accepted
+// connection, given the first frame read from it, and returns the
+// negotiated features.  A sender that did not announce itself is a
+// legacy service, and is not greeted.
+func (ms *messageService) greet(conn net.Conn, first *api.Message) (Feature, bool) {
+	if ms.opts.Legacy || first.Version == 0 && first.Kind != api.Kind_HANDSHAKE {
+		return 0, false
+	}
+	version := first.Version
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+	features := ms.features() & Feature(first.Features) & versionFeatures(version)
+	var credit uint32
+	if cap(ms.receiver) == 0 {
+		// An unbuffered queue has no free space to grant.
+		features &^= FeatureCredit
+	} else {
+		credit = ms.credit()
+	}
+	var maxFrames uint32
+	if ms.opts.MaxFramesPerConnection > 0 {
+		maxFrames = uint32(ms.opts.MaxFramesPerConnection)
+	}
+	reply, err := marshalFrame(&api.Message{
+		Sender:    ms.id,
+		Kind:      api.Kind_HANDSHAKE,
+		Version:   version,
+		MaxFrames: maxFrames,
+		Features:  uint64(features),
+		Credit:    credit,
+	})
+	if err == nil {
+		conn.Write(reply)
+	}
+	return features, true
+}
+
+// credit returns the credit to grant a sender, which is the free
+// space in the receive queue.
+func (ms *messageService) credit() uint32 {
+	return uint32(cap(ms.receiver) - len(ms.receiver))
+}
+
+// negotiated returns the features last negotiated with addr, and
+// whether any have been.  Zero features means a legacy service.
+func (ms *messageService) negotiated(addr string) (Feature, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	features, ok := ms.peerFeatures[addr]
+	return features, ok
+}
+
+// setNegotiated records the features negotiated with addr.
+func (ms *messageService) setNegotiated(addr string, features Feature) {
+	ms.mu.Lock()
+	ms.peerFeatures[addr] = features
+	ms.mu.Unlock()
+}
+
+// handshake sends a bare HANDSHAKE on the new connection pc and
+// waits for the receiver's reply, which gives pc its features and
+// credit.  It returns errLegacyPeer if there was no reply, in which
+// case the receiver has closed the connection or will once it has
+// read the HANDSHAKE.
+func (ms *messageService) handshake(pc *pooledConn) error {
+	frame, err := marshalFrame(ms.hello())
+	if err != nil {
+		return err
+	}
+	if err := pc.write(frame); err != nil {
+		return err
+	}
+	pc.conn.SetReadDeadline(time.Now().Add(ms.opts.HandshakeTimeout))
+	reply, err := pc.fr.readFrame()
+	pc.conn.SetReadDeadline(time.Time{})
+	if err != nil || reply.Kind != api.Kind_HANDSHAKE {
+		ms.setNegotiated(pc.addr, 0)
+		return errLegacyPeer
+	}
+	ms.negotiate(pc, reply)
+	return nil
+}
+
+// tooLong reports whether msg is too long to be encoded for a peer
+// with the given features.
+func (ms *messageService) tooLong(msg *api.Message, features Feature, announce bool) bool {
+	var tooLong *api.MessageTooLong
+	_, err := ms.encode(msg, features, announce)
+	return errors.As(err, &tooLong)
+}
+
+// encode marshals msg into a frame for a peer with the given
+// features, decompressing its data for peers that do not support
+// compression.  It returns an *UnsupportedError if msg needs a
+// feature that the peer does not support.  If announce is set, the frame also carries
+// this service's version and features, as the first frame that it
+// sends on a connection must.
+func (ms *messageService) encode(msg *api.Message, features Feature, announce bool) ([]byte, error) {
+	if msg.Ttl > 0 && features&FeatureRouting == 0 {
+		return nil, &UnsupportedError{Feature: FeatureRouting}
+	}
+	decompressed := msg.Compression != api.Compression_NONE && features&FeatureCompression == 0
+	if decompressed || announce {
+		msg = proto.Clone(msg).(*api.Message)
+	}
+	if decompressed {
+		if err := decompress(msg, math.MaxInt32); err != nil {
+			return nil, &UnsupportedError{FeatureCompression, err}
+		}
+	}
+	if announce {
+		hello := ms.hello()
+		msg.Version, msg.Features = hello.Version, hello.Features
+	}
+	return marshalFrame(msg)
+}
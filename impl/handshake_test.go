@@ -0,0 +1,212 @@
+package impl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestLegacyReceiver sends to a receiver that speaks only the
+// original protocol and ensures that it receives one frame holding
+// the message, without the sender waiting for a handshake, even when
+// flow control would have the sender ask for credit first.
+func TestLegacyReceiver(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts Options
+	}{
+		{"Plain", Options{}},
+		{"FlowControl", Options{FlowControl: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := impltest.Listen(t)
+			received := make(chan []byte, 2)
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					b, _ := io.ReadAll(conn)
+					conn.Close()
+					received <- b
+				}
+			}()
+
+			tc.opts.Directory = directory.NewStatic(map[string]string{staticMsgSender: impltest.FreeAddr(t), staticMsgRecipient: l.Addr().String()})
+			tc.opts.HandshakeTimeout = 5 * time.Second
+			tc.opts.Compression = api.Compression_GZIP
+			gray, err := NewMessageServiceWithOptions(staticMsgSender, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not create service: %v", err)
+			}
+			defer gray.Close()
+
+			for i := 0; i < 2; i++ {
+				start := time.Now()
+				if err := gray.Send(staticMsgRecipient, staticMsgText[:]); err != nil {
+					t.Fatalf("Send failed: %v", err)
+				}
+				if d := time.Since(start); d >= time.Second {
+					t.Errorf("Send %d took %v", i, d)
+				}
+				b := <-received
+				msg := &api.Message{}
+				if len(b) < 2 || int(b[0])<<8|int(b[1]) != len(b)-2 || proto.Unmarshal(b[2:], msg) != nil {
+					t.Fatalf("Legacy receiver got %x", b)
+				}
+				if msg.Sender != staticMsgSender || msg.Recipient != staticMsgRecipient ||
+					msg.Kind != api.Kind_DATA || !bytes.Equal(msg.Data, staticMsgText[:]) {
+					t.Errorf("Legacy receiver got %v", msg)
+				}
+				// Every frame announces the sender, which costs a
+				// legacy receiver nothing and notices if it is
+				// upgraded.
+				if msg.Version != ProtocolVersion {
+					t.Errorf("Frame %d has version %d", i, msg.Version)
+				}
+			}
+		})
+	}
+}
+
+// TestVersionFeatures announces an older protocol version to a
+// receiver and ensures that it grants only that version's features.
+func TestVersionFeatures(t *testing.T) {
+	addr := impltest.FreeAddr(t)
+	bob, err := NewMessageServiceWithOptions(staticMsgRecipient, Options{
+		Directory: directory.NewStatic(map[string]string{staticMsgRecipient: addr}),
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	hello, _ := marshalFrame(&api.Message{Kind: api.Kind_HANDSHAKE, Version: 1, Features: uint64(AllFeatures)})
+	if _, err := conn.Write(hello); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := newFrameReader(conn).readFrame()
+	if err != nil {
+		t.Fatalf("No handshake reply: %v", err)
+	}
+	if reply.Version != 1 || Feature(reply.Features)&^versionFeatures(1) != 0 {
+		t.Errorf("Version 1 sender got version %d, features %b", reply.Version, reply.Features)
+	}
+}
+
+// TestLegacySender sends from a sender that speaks only the original
+// protocol and ensures that it is not greeted, and that its message
+// is delivered.
+func TestLegacySender(t *testing.T) {
+	addr := impltest.FreeAddr(t)
+	bob, err := NewMessageServiceWithOptions(staticMsgRecipient, Options{
+		Directory: directory.NewStatic(map[string]string{staticMsgRecipient: addr}),
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(staticMsg[:]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if b, err := io.ReadAll(conn); err != nil || len(b) != 0 {
+		t.Errorf("Legacy sender read %x, %v", b, err)
+	}
+	if msg := <-bob.Receiver(); !bytes.Equal(msg.Data, staticMsgText[:]) {
+		t.Errorf("Received %v", msg)
+	}
+}
+
+// TestNegotiation sends between services with differing features
+// and ensures that messages use only features both support.
+func TestNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		alice, bob Options
+	}{
+		{"New", Options{}, Options{}},
+		{"LegacySender", Options{Legacy: true}, Options{}},
+		{"LegacyReceiver", Options{}, Options{Legacy: true}},
+		{"NoCompression", Options{}, Options{DisableFeatures: FeatureCompression}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t)})
+			tc.alice.Directory, tc.bob.Directory = dir, dir
+			tc.alice.Compression = api.Compression_GZIP
+			tc.alice.HandshakeTimeout = 50 * time.Millisecond
+			// bob refuses compressed data, so a message that
+			// arrives compressed is rejected.
+			tc.bob.MaxDecompressedLen = 1
+			alice, err := NewMessageServiceWithOptions("alice", tc.alice)
+			if err != nil {
+				t.Fatalf("Could not create service: %v", err)
+			}
+			defer alice.Close()
+			bob, err := NewMessageServiceWithOptions("bob", tc.bob)
+			if err != nil {
+				t.Fatalf("Could not create service: %v", err)
+			}
+			defer bob.Close()
+
+			// The first message negotiates features, and is
+			// too short to be compressed.
+			if err := alice.Send("bob", []byte("hello")); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			<-bob.Receiver()
+			err = alice.Send("bob", make([]byte, 4096))
+			if compressed := tc.name == "New"; compressed != (err != nil) {
+				t.Errorf("Send returned %v", err)
+			}
+			if err == nil {
+				if rmsg := <-bob.Receiver(); len(rmsg.Data) != 4096 {
+					t.Errorf("Received %d bytes", len(rmsg.Data))
+				}
+			}
+		})
+	}
+}
+
+// TestUnsupported ensures that a message needing a feature that the
+// recipient does not support is refused with an *UnsupportedError.
+func TestUnsupported(t *testing.T) {
+	ms := &messageService{opts: Options{}.withDefaults()}
+	for _, tc := range []struct {
+		msg  *api.Message
+		want Feature
+	}{
+		{&api.Message{Ttl: 2}, FeatureRouting},
+		{&api.Message{Compression: api.Compression_GZIP, Data: []byte("not gzip")}, FeatureCompression},
+	} {
+		var unsupported *UnsupportedError
+		if _, err := ms.encode(tc.msg, 0, false); !errors.As(err, &unsupported) || unsupported.Feature != tc.want {
+			t.Errorf("encode(%v) returned %v, expected %v unsupported", tc.msg, err, tc.want)
+		}
+	}
+	if s := (FeatureRouting | FeatureCredit).String(); s != "routing|credit" {
+		t.Errorf("Features are %q", s)
+	}
+}
@@ -0,0 +1,74 @@
+package impl
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestStaticMsgEncoding ensures that a message without headers still
+// has the original three-field encoding.
+func TestStaticMsgEncoding(t *testing.T) {
+	frame, err := marshalFrame(&api.Message{
+		Sender:    staticMsgSender,
+		Recipient: staticMsgRecipient,
+		Data:      staticMsgText[:],
+	})
+	if err != nil {
+		t.Fatalf("marshalFrame failed: %v", err)
+	}
+	if !bytes.Equal(frame, staticMsg[:]) {
+		t.Errorf("Encoding changed:\n%x\n%x", frame, staticMsg[:])
+	}
+}
+
+// TestSendMessageHeaders sends a message with headers and ensures
+// that they arrive along with an ID and timestamp.
+func TestSendMessageHeaders(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t)})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	msg := &api.Message{Sender: "mallory", Recipient: "bob", Data: []byte("hello")}
+	msg.SetHeader("content-type", "text/plain")
+	msg.SetHeader("trace-id", "abc123")
+	before := time.Now()
+	if err := alice.SendMessage(msg); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if msg.Id != "" || msg.Sender != "mallory" {
+		t.Error("SendMessage modified its argument")
+	}
+
+	rmsg := <-bob.Receiver()
+	if rmsg.Sender != "alice" || string(rmsg.Data) != "hello" {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+	if rmsg.Header("content-type") != "text/plain" || rmsg.Header("trace-id") != "abc123" || rmsg.Header("none") != "" {
+		t.Errorf("Unexpected headers %v", rmsg.Headers)
+	}
+	if len(rmsg.Id) != 32 {
+		t.Errorf("Unexpected message ID %q", rmsg.Id)
+	}
+	if ts := rmsg.Time(); ts.Before(before) || ts.After(time.Now()) {
+		t.Errorf("Unexpected timestamp %v", ts)
+	}
+
+	// Plain messages have no ID or timestamp.
+	alice.Send("bob", []byte("plain"))
+	if rmsg := <-bob.Receiver(); rmsg.Id != "" || !rmsg.Time().IsZero() || rmsg.Headers != nil {
+		t.Errorf("Unexpected metadata on plain message %v", rmsg)
+	}
+}
@@ -0,0 +1,66 @@
+// Package impltest provides the fixtures shared by the tests of
+// package impl and the packages built on it: loopback addresses,
+// directories that hold them, and services that are closed when the
+// test ends.  It does not import package impl, so that impl's own
+// tests can use it.
+package impltest
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"cse586.messageservice/given/directory"
+)
+
+// Listen returns a listener on a loopback address, which is closed
+// when the test ends.
+func Listen(t testing.TB) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// FreeAddr returns a loopback address with a port that was free at
+// the time of the call.
+func FreeAddr(t testing.TB) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// Directory returns a Static directory that gives each of ids its own
+// free loopback address.
+func Directory(t testing.TB, ids ...string) *directory.Static {
+	t.Helper()
+	addrs := make(map[string]string)
+	for _, id := range ids {
+		addrs[id] = FreeAddr(t)
+	}
+	return directory.NewStatic(addrs)
+}
+
+// Start calls start for each of ids in turn and returns the services
+// it creates, which are closed when the test ends.  The test fails at
+// once if start returns an error.
+func Start[S io.Closer](t testing.TB, start func(id string) (S, error), ids ...string) []S {
+	t.Helper()
+	services := make([]S, 0, len(ids))
+	for _, id := range ids {
+		s, err := start(id)
+		if err != nil {
+			t.Fatalf("Could not create service %s: %v", id, err)
+		}
+		t.Cleanup(func() { s.Close() })
+		services = append(services, s)
+	}
+	return services
+}
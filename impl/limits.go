@@ -0,0 +1,35 @@
+package impl
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Defaults for the limits on incoming connections.  See Options.
+const (
+	DefaultMaxConnections         = 1024
+	DefaultIdleTimeout            = 30 * time.Second
+	DefaultReadTimeout            = 10 * time.Second
+	DefaultMaxFramesPerConnection = 1024
+)
+
+var (
+	errTooManyConnections = errors.New("too many connections")
+	errFrameLimit         = errors.New("too many frames on connection")
+)
+
+// admit counts a newly accepted connection against
+// Options.MaxConnections.  If the limit has been reached, it closes
+// conn, reports it, and returns false.
+func (ms *messageService) admit(conn net.Conn) bool {
+	max := ms.opts.MaxConnections
+	if n := ms.active.Add(1); max > 0 && n > int64(max) {
+		ms.active.Add(-1)
+		conn.Close()
+		ms.counters.refused.Add(1)
+		ms.reportError(ConnectionLimit, conn.RemoteAddr(), errTooManyConnections)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package impl
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl/impltest"
+)
+
+// newLimitedService creates a service for staticMsgRecipient with
+// opts and returns it with its address.
+func newLimitedService(t *testing.T, opts Options) (Service, string) {
+	t.Helper()
+	dir := impltest.Directory(t, staticMsgRecipient)
+	addr, _ := dir.Lookup(staticMsgRecipient)
+	return impltest.Start(t, start(dir, opts), staticMsgRecipient)[0], addr
+}
+
+// expectKind receives the next error from ms and checks its kind.
+func expectKind(t *testing.T, ms Service, want ErrorKind) {
+	t.Helper()
+	select {
+	case err := <-ms.Errors():
+		var rerr *ReceiveError
+		if !errors.As(err, &rerr) || rerr.Kind != want {
+			t.Errorf("Expected %v, got %v", want, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("No %v error", want)
+	}
+}
+
+// TestMaxConnections ensures that connections beyond the limit are
+// closed on arrival.
+func TestMaxConnections(t *testing.T) {
+	ms, addr := newLimitedService(t, Options{MaxConnections: 1})
+	held, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	// Wait for the reply to a handshake, so that the first
+	// connection has been accepted.
+	hello, _ := marshalFrame(&api.Message{Kind: api.Kind_HANDSHAKE, Version: ProtocolVersion})
+	held.Write(hello)
+	newFrameReader(held).readFrame()
+
+	refused, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	refused.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := refused.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Refused connection read %d, %v", n, err)
+	}
+	expectKind(t, ms, ConnectionLimit)
+	if n := ms.Stats().RefusedConnections; n != 1 {
+		t.Errorf("RefusedConnections = %d", n)
+	}
+}
+
+// TestIdleTimeout ensures that a connection that sends nothing is
+// closed.
+func TestIdleTimeout(t *testing.T) {
+	ms, addr := newLimitedService(t, Options{IdleTimeout: 50 * time.Millisecond})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Half a frame header stalls the reader as well.
+	conn.Write([]byte{0})
+
+	expectKind(t, ms, Timeout)
+	if n := ms.Stats().TimedOut; n != 1 {
+		t.Errorf("TimedOut = %d", n)
+	}
+}
+
+// TestMaxFramesPerConnection ensures that a connection is closed
+// once it sends more than the maximum number of frames.
+func TestMaxFramesPerConnection(t *testing.T) {
+	ms, addr := newLimitedService(t, Options{MaxFramesPerConnection: 2})
+	for _, n := range []int{2, 3} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			conn.Write(staticMsg[:])
+		}
+		conn.Close()
+	}
+
+	expectKind(t, ms, ConnectionLimit)
+	for i := 0; i < 4; i++ {
+		<-ms.Receiver()
+	}
+	if stats := ms.Stats(); stats.FrameLimited != 1 || stats.Received != 4 {
+		t.Errorf("Stats = %+v", stats)
+	}
+}
@@ -0,0 +1,67 @@
+package impl
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/given/logging"
+	"cse586.messageservice/impl/impltest"
+)
+
+// syncBuffer is a bytes.Buffer that may be written concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestLogging sends a message and a failing message and checks the
+// events logged by the sender and the recipient.
+func TestLogging(t *testing.T) {
+	var out syncBuffer
+	logger := logging.New(&out, logging.LevelDebug)
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t)})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, Logger: logger})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir, Logger: logger})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+
+	if err := alice.Send("bob", []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	<-bob.Receiver()
+	alice.Send("carol", nil)
+	bob.Close()
+
+	addr, _ := dir.Lookup("bob")
+	for _, want := range []string{
+		`level=INFO msg=listening service=bob addr=` + addr,
+		`level=DEBUG msg=connected service=alice peer=bob addr=` + addr,
+		`level=DEBUG msg="connection accepted" service=bob remote=`,
+		`level=DEBUG msg="connection closed" service=bob remote=`,
+		`level=WARN msg="send failed" service=alice peer=carol error="unknown recipient ID: carol"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Log lacks %s:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package impl
+
+import (
+	"sync"
+	"time"
+
+	"cse586.messageservice/given/directory"
+)
+
+// DefaultLookupTTL is the lookup cache TTL used when
+// Options.LookupTTL is zero.
+const DefaultLookupTTL = 10 * time.Second
+
+// lookupCache caches directory lookups so that Send does not make a
+// round trip to the directory for every message.  Entries expire
+// after ttl, are dropped when the directory reports a change, and can
+// be invalidated explicitly when the cached address stops working.
+type lookupCache struct {
+	dir directory.Directory
+	ttl time.Duration // ttl <= 0 disables caching
+
+	// now is the clock used for expiry; tests replace it.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]lookupEntry
+
+	// cancel stops the goroutine watching the directory.
+	cancel func()
+}
+
+// lookupEntry is a cached list of addresses and the time it
+// expires.
+type lookupEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// newLookupCache creates a cache in front of dir.  If caching is
+// enabled, it watches dir for changes until close is called.
+func newLookupCache(dir directory.Directory, ttl time.Duration) *lookupCache {
+	c := &lookupCache{
+		dir:     dir,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]lookupEntry),
+		cancel:  func() {},
+	}
+	if ttl > 0 {
+		events, cancel := dir.Watch()
+		c.cancel = cancel
+		go func() {
+			for ev := range events {
+				c.invalidate(ev.Entry.ID)
+			}
+		}()
+	}
+	return c
+}
+
+// lookup returns the addresses of id in order of preference, from
+// the cache if possible.  Unknown IDs are not cached.  The returned
+// slice must not be modified.
+func (c *lookupCache) lookup(id string) ([]string, bool) {
+	if c.ttl <= 0 {
+		return c.dir.LookupAll(id)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.addrs, true
+	}
+
+	addrs, ok := c.dir.LookupAll(id)
+	if !ok || len(addrs) == 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.entries[id] = lookupEntry{addrs, c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, true
+}
+
+// invalidate drops any cached address for id.
+func (c *lookupCache) invalidate(id string) {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+}
+
+// close stops watching the directory.
+func (c *lookupCache) close() {
+	c.cancel()
+}
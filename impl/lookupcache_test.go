@@ -0,0 +1,111 @@
+package impl
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// countingDirectory counts lookups on a Static directory and never
+// reports changes to watchers, so that only expiry and explicit
+// invalidation refresh the cache.
+type countingDirectory struct {
+	*directory.Static
+	lookups int32
+}
+
+func (d *countingDirectory) LookupAll(id string) ([]string, bool) {
+	atomic.AddInt32(&d.lookups, 1)
+	return d.Static.LookupAll(id)
+}
+
+func (d *countingDirectory) Watch() (<-chan directory.Event, func()) {
+	return make(chan directory.Event), func() {}
+}
+
+// TestLookupCacheExpiry ensures that lookups are served from the
+// cache until the TTL expires.
+func TestLookupCacheExpiry(t *testing.T) {
+	dir := &countingDirectory{Static: directory.NewStatic(map[string]string{"bob": "localhost:1"})}
+	c := newLookupCache(dir, time.Minute)
+	defer c.close()
+	clock := time.Unix(1000, 0)
+	c.now = func() time.Time { return clock }
+
+	for i := 0; i < 3; i++ {
+		if addrs, ok := c.lookup("bob"); !ok || addrs[0] != "localhost:1" {
+			t.Errorf("lookup(bob) = %v, %v", addrs, ok)
+		}
+	}
+	if n := atomic.LoadInt32(&dir.lookups); n != 1 {
+		t.Errorf("Expected 1 directory lookup, got %d", n)
+	}
+
+	clock = clock.Add(2 * time.Minute)
+	c.lookup("bob")
+	if n := atomic.LoadInt32(&dir.lookups); n != 2 {
+		t.Errorf("Expected a directory lookup after expiry, got %d", n)
+	}
+}
+
+// TestLookupCacheWatch ensures that a change reported by the
+// directory invalidates the cached address.
+func TestLookupCacheWatch(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"bob": "localhost:1"})
+	c := newLookupCache(dir, time.Hour)
+	defer c.close()
+
+	c.lookup("bob")
+	dir.Set("bob", "localhost:2")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if addrs, _ := c.lookup("bob"); addrs[0] == "localhost:2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Cached address was not invalidated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestSendRefreshesStaleAddress moves a recipient to a new address
+// without notifying the sender's cache, and ensures that Send
+// recovers by looking the recipient up again.
+func TestSendRefreshesStaleAddress(t *testing.T) {
+	dir := &countingDirectory{Static: directory.NewStatic(map[string]string{
+		"alice": impltest.FreeAddr(t),
+		"bob":   impltest.FreeAddr(t),
+	})}
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, LookupTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	if err := alice.Send("bob", []byte("first")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	<-bob.Receiver()
+	bob.Close()
+
+	dir.Set("bob", impltest.FreeAddr(t))
+	bob, err = NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+	if err := alice.Send("bob", []byte("second")); err != nil {
+		t.Fatalf("Send to moved recipient failed: %v", err)
+	}
+	if rmsg := <-bob.Receiver(); string(rmsg.Data) != "second" {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+}
@@ -11,20 +11,100 @@ policies for more package.
 package impl
 
 import (
+	"crypto/rand"
 	"cse586.messageservice/api"
 	"cse586.messageservice/given/directory"
+	"cse586.messageservice/given/logging"
+	"cse586.messageservice/impl/trace"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	//proto "github.com/golang/protobuf/proto"
-	"google.golang.org/protobuf/proto"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
 )
 
+// Service is the interface implemented by the MessageServices that
+// this package creates.  It extends api.MessageService with features
+// that are specific to this implementation.
+type Service interface {
+	api.MessageService
+
+	// Stats returns a snapshot of the service's counters.
+	Stats() Stats
+
+	// Errors returns a channel that reports errors that occur
+	// while receiving.  See ReceiveError.
+	Errors() <-chan error
+
+	// SendMessage sends msg to msg.Recipient, with its headers.
+	// The sender and the protocol fields are filled in by the
+	// service, as are the message ID and timestamp if they are
+	// unset.  msg itself is not modified.  Errors are as for
+	// Send.
+	SendMessage(msg *api.Message) error
+
+	// CheckSend returns the error that Send would return for a
+	// message too long to send, without sending it.
+	CheckSend(recipient string, data []byte) error
+}
+
 type messageService struct {
 	id string
 	// message  api.Message
-	listener net.Listener
-	receiver chan *api.Message
+	opts      Options
+	dir       directory.Directory
+	lookups   *lookupCache
+	listeners []io.Closer
+	receiver  chan *api.Message
+	errors    chan error
+
+	counters counters
+	metrics  *instruments
+	log      logging.Logger
+
+	// sendLimit and receiveLimit apply Options.SendRate and
+	// Options.ReceiveRate.  They are nil if there is no limit.
+	sendLimit    *limiter
+	receiveLimit *limiter
+
+	// active counts accepted connections that are being handled.
+	active atomic.Int64
+
+	// done is closed when Close is first called.
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	// wg counts the goroutines registered with begin or track.
+	wg sync.WaitGroup
+
+	mu sync.Mutex
+	// lastAddr is the address that most recently accepted a
+	// connection for each recipient.
+	lastAddr map[string]string
+	// learned maps the originators of routed messages to the
+	// relay they last arrived through.
+	learned map[string]string
+	// peers holds the send queues of recipients with messages
+	// waiting to be sent.  It is guarded by schedMu.
+	peers   map[string]*peerQueue
+	schedMu sync.Mutex
+	sendSeq uint64
+
+	// peerFeatures maps addresses to the features last
+	// negotiated with them, which are zero for legacy services.
+	peerFeatures map[string]Feature
+	// pool holds the idle connections to each recipient.
+	pool map[string][]*pooledConn
+	// conns holds every open inbound and outbound connection.
+	conns map[net.Conn]struct{}
+	// closed is set, under mu, when Close is first called.
+	closed bool
 }
 
 // NewMessageService creates an implementation of the MessageService API,
@@ -32,26 +112,74 @@ type messageService struct {
 //
 // This method must return an error if id is not known to the
 // directory service, or if a listening socket cannot be established
-// on the address associated with id.  Otherwise, it should return a
+// on every address associated with id.  Otherwise, it should return a
 // working MessageService implementation.
+//
+// Addresses may name Unix domain sockets, as in
+// "unix:/tmp/lynch.sock", as well as TCP ports.  Socket files are
+// removed when the service is closed.
 func NewMessageService(id string) (api.MessageService, error) {
-	addr, ok := directory.Lookup(id)
+	return NewMessageServiceWithOptions(id, Options{})
+}
+
+// NewMessageServiceWithOptions is like NewMessageService, but allows
+// the caller to configure the service, for example to supply a
+// directory backend other than directory.Default.
+func NewMessageServiceWithOptions(id string, opts Options) (Service, error) {
+	opts = opts.withDefaults()
+	addrs, ok := opts.Directory.LookupAll(id)
 	if !ok {
 		return nil, fmt.Errorf("invalid id: %v", id)
 	}
 
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to listen: %v", err)
-	}
-
 	ms := &messageService{
-		id:       id,
-		listener: listener,
-		receiver: make(chan *api.Message),
+		id:           id,
+		opts:         opts,
+		dir:          opts.Directory,
+		receiver:     make(chan *api.Message, opts.ReceiveBuffer),
+		errors:       make(chan error, opts.ErrorBuffer),
+		done:         make(chan struct{}),
+		lastAddr:     make(map[string]string),
+		learned:      make(map[string]string),
+		peerFeatures: make(map[string]Feature),
+		pool:         make(map[string][]*pooledConn),
+		peers:        make(map[string]*peerQueue),
+		conns:        make(map[net.Conn]struct{}),
+		lookups:      newLookupCache(opts.Directory, opts.LookupTTL),
+		log:          logging.With(logging.OrDiscard(opts.Logger), "service", id),
+
+		sendLimit:    newLimiter(opts.SendRate),
+		receiveLimit: newLimiter(opts.ReceiveRate),
 	}
+	ms.metrics = newInstruments(ms, opts.Metrics)
 
-	go ms.listen()
+	for _, addr := range addrs {
+		var err error
+		if opts.Transport == DatagramTransport {
+			var conn net.PacketConn
+			if conn, err = listenDatagram(addr); err == nil {
+				ms.listeners = append(ms.listeners, conn)
+				ms.begin()
+				go ms.listenDatagram(conn)
+			}
+		} else {
+			var listener net.Listener
+			if listener, err = listenAddress(addr); err == nil {
+				ms.listeners = append(ms.listeners, listener)
+				ms.begin()
+				go ms.listen(listener)
+			}
+		}
+		if err != nil {
+			for _, l := range ms.listeners {
+				l.Close()
+			}
+			ms.lookups.close()
+			ms.metrics.close()
+			return nil, fmt.Errorf("failed to listen: %v", err)
+		}
+		ms.log.Info("listening", "addr", addr)
+	}
 
 	return ms, nil
 }
@@ -60,12 +188,6 @@ func (ms *messageService) Receiver() <-chan *api.Message {
 	return ms.receiver
 }
 
-func (ms *messageService) Close() error {
-	err := ms.listener.Close()
-	close(ms.receiver)
-	return err
-}
-
 func BytesToInt(b []byte) int {
 	return int(binary.BigEndian.Uint16(b))
 }
@@ -76,90 +198,377 @@ func Int16ToBytes(i int16) []byte {
 	return buf
 }
 
-func (ms *messageService) listen() {
+func (ms *messageService) listen(listener net.Listener) {
+	defer ms.end()
 	for {
-		conn, err := ms.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			if ms.isClosed() {
+				break
+			}
+			ms.reportError(AcceptFailed, listener.Addr(), err)
 			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
 				continue
 			}
-			if err == net.ErrClosed {
-				break
-			}
 			break
 		}
 
-		total_length := 0
-		go func() {
-			defer conn.Close()
-			buf := make([]byte, 0)
-			for i := 0; ; i++ {
-				tmp := make([]byte, api.MaxMessageLen)
-				n, err := conn.Read(tmp)
-				if err != nil {
-					break
-				}
+		if !ms.admit(conn) {
+			continue
+		}
+		if !ms.track(conn) {
+			ms.active.Add(-1)
+			conn.Close()
+			break
+		}
 
-				buf = append(buf, tmp[:n]...)
-				if i == 0 {
-					total_length = BytesToInt(tmp[:2])
-					total_length += 2
-					// message too long
-					if total_length > api.MaxMessageLen {
-						break
-					}
-				}
+		go ms.handle(conn)
+	}
+}
+
+// handle reads frames from an accepted connection until the sender
+// closes it, delivering each message to the receive queue.  Frames
+// that are too long or cannot be decoded are reported and skipped.
+func (ms *messageService) handle(conn net.Conn) {
+	defer ms.untrack(conn)
+	defer ms.active.Add(-1)
+	defer conn.Close()
+
+	ms.log.Debug("connection accepted", "remote", conn.RemoteAddr())
+	defer ms.log.Debug("connection closed", "remote", conn.RemoteAddr())
 
-				total_length -= n
-				if total_length <= 0 {
-					break
+	fr := newFrameReader(conn)
+	fr.conn, fr.idleTimeout, fr.readTimeout = conn, ms.opts.IdleTimeout, ms.opts.ReadTimeout
+	// peer is the set of features negotiated with the sender,
+	// which is zero if it did not announce itself.
+	var peer Feature
+	var announced bool
+	for frames := 1; ; frames++ {
+		msg, err := fr.readFrame()
+		if err == io.EOF {
+			return
+		}
+		if max := ms.opts.MaxFramesPerConnection; max > 0 && frames > max {
+			ms.counters.frameLimited.Add(1)
+			ms.reportError(ConnectionLimit, conn.RemoteAddr(), errFrameLimit)
+			return
+		}
+		if err != nil {
+			// Errors reading from a connection that Close
+			// has shut down are expected.
+			if !ms.isClosed() {
+				kind := receiveErrorKind(err)
+				if kind == Timeout {
+					ms.counters.timedOut.Add(1)
 				}
+				ms.reportError(kind, conn.RemoteAddr(), err)
 			}
-
-			binData := buf[2:]
-			msg := &api.Message{}
-			err = proto.Unmarshal(binData, msg)
-			//err = msg.XXX_Unmarshal(binData)
-			if err != nil {
-				return
+			if recoverable(err) {
+				continue
 			}
-			ms.receiver <- msg
-		}()
+			return
+		}
+		if frames == 1 {
+			// A legacy sender is never greeted, so
+			// nothing is written to it but refusals,
+			// just as before handshakes existed.
+			peer, announced = ms.greet(conn, msg)
+			msg.Version, msg.Features = 0, 0
+		}
+		switch {
+		case msg.Kind == api.Kind_DATA:
+			ms.receive(conn, msg, peer, announced)
+		case msg.Kind == api.Kind_HANDSHAKE:
+		case msg.Kind == api.Kind_CREDIT && peer&FeatureCredit != 0:
+			ms.reply(conn, &api.Message{Kind: api.Kind_CREDIT, Credit: ms.credit()})
+		default:
+			// Replies and refusals travel only from
+			// receiver to sender.
+			ms.reportError(UnmarshalFailed, conn.RemoteAddr(), unexpectedKind(msg))
+		}
+	}
+}
+
+// receive accepts a message read from conn, telling the sender why if
+// it is refused.  A sender with persistent connections is also told
+// if it is accepted.
+func (ms *messageService) receive(conn net.Conn, msg *api.Message, peer Feature, announced bool) {
+	err := ms.accept(msg, conn.RemoteAddr())
+	reply := &api.Message{Recipient: msg.Sender}
+	var throttled *ThrottledError
+	switch {
+	case err == nil && peer&FeaturePersistent == 0:
+		return
+	case err == nil:
+		reply.Kind = api.Kind_ACK
+		if peer&FeatureCredit != 0 {
+			reply.Credit = ms.credit()
+		}
+	case announced && errors.As(err, &throttled):
+		// Legacy senders understand only ERROR frames.
+		reply.Kind, reply.Error = api.Kind_THROTTLED, throttled.Reason
+		reply.RetryAfter = int64(throttled.RetryAfter)
+	default:
+		reply.Kind, reply.Error, reply.Temporary = api.Kind_ERROR, err.Error(), temporary(err)
+	}
+	ms.reply(conn, reply)
+}
+
+// reply sends msg from this service back over conn.
+func (ms *messageService) reply(conn net.Conn, msg *api.Message) {
+	msg.Sender = ms.id
+	if frame, err := marshalFrame(msg); err == nil {
+		conn.Write(frame)
 	}
 }
 
 func (ms *messageService) Send(recipient string, data []byte) error {
-	addr, ok := directory.Lookup(recipient)
-	if !ok {
-		return fmt.Errorf("unknown recipient ID: %s", recipient)
+	if ms.isClosed() {
+		return errClosed
 	}
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to connect: %v", err)
+
+	msg := &api.Message{
+		Sender:    ms.id,
+		Recipient: recipient,
+		Data:      data,
+		Priority:  ms.opts.Priority,
 	}
-	defer conn.Close()
+	return ms.send(msg)
+}
+
+func (ms *messageService) SendMessage(msg *api.Message) error {
+	if ms.isClosed() {
+		return errClosed
+	}
+
+	msg = proto.Clone(msg).(*api.Message)
+	msg.Sender = ms.id
+	msg.Kind = api.Kind_DATA
+	msg.Error = ""
+	msg.Ttl = 0
+	msg.Path = nil
+	msg.Compression = api.Compression_NONE
+	if msg.Priority == api.Priority_NORMAL {
+		msg.Priority = ms.opts.Priority
+	}
+	if msg.Id == "" {
+		msg.Id = newMessageID()
+	}
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixNano()
+	}
+	return ms.send(msg)
+}
+
+// send compresses and routes an outgoing message.
+func (ms *messageService) send(msg *api.Message) (err error) {
+	span := ms.startSendSpan(msg)
+	defer func() {
+		span.Finish(err)
+		ms.metrics.send(msg, err)
+		if err != nil {
+			ms.log.Warn("send failed", "peer", msg.Recipient, "error", err)
+		}
+	}()
+	if ok, wait := ms.sendLimit.allow(msg.Recipient); !ok {
+		return &ThrottledError{msg.Recipient, "send rate limit exceeded", wait}
+	}
+	if err := ms.compress(msg); err != nil {
+		return err
+	}
+	return ms.route(msg)
+}
+
+// CheckSend returns the error that Send would return because the
+// message is too long, without sending it.
+func (ms *messageService) CheckSend(recipient string, data []byte) error {
 	msg := &api.Message{
 		Sender:    ms.id,
 		Recipient: recipient,
 		Data:      data,
+		Priority:  ms.opts.Priority,
+	}
+	if ms.opts.Tracer != nil {
+		// The context of the span is not known yet, but its
+		// header is always the same length.
+		trace.Inject(msg, trace.SpanContext{})
+	}
+	if len(ms.opts.Routes) > 0 {
+		// The message may be sent through a relay.
+		msg.Ttl = uint32(ms.opts.MaxHops)
+	}
+	if err := ms.compress(msg); err != nil {
+		return err
 	}
+	return checkFrame(msg)
+}
+
+// newMessageID returns a random 128-bit message ID in hexadecimal.
+func newMessageID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
 
-	var datas []byte
-	datas, _ = proto.Marshal(msg)
-	//msg.XXX_Marshal(datas, true)
-	startBinData := Int16ToBytes(int16(len(datas)))
-	datas = append(startBinData, datas[:]...)
-	data_length := len(datas)
-	if data_length > api.MaxMessageLen {
-		return &api.MessageTooLong{
-			//Msg: fmt.Sprintf("Message is %d bytes", data_length),
+// transmitNow sends msg to recipient over the configured transport,
+// encoded for the features that the recipient supports.  It is called
+// by the scheduler; see transmit.
+func (ms *messageService) transmitNow(recipient string, msg *api.Message) error {
+	if ms.opts.Transport == DatagramTransport {
+		datas, err := ms.encode(msg, ms.features(), false)
+		if err != nil {
+			return err
 		}
+		return ms.sendDatagram(recipient, datas)
+	}
+
+	err := ms.transmitStream(recipient, msg)
+	if err == errLegacyPeer {
+		// The recipient took the HANDSHAKE for a message and
+		// closed the connection.  It is now known to be a
+		// legacy service, so msg is sent to it as one.
+		err = ms.transmitStream(recipient, msg)
+	}
+	return err
+}
+
+// transmitStream sends msg to recipient over a stream connection,
+// reusing a pooled one if there is one, and otherwise beginning with
+// the handshake.
+func (ms *messageService) transmitStream(recipient string, msg *api.Message) error {
+	if pc := ms.takeConn(recipient); pc != nil {
+		return ms.sendPooled(recipient, pc, msg, false)
 	}
 
-	_, err = conn.Write(datas)
+	conn, addr, err := ms.dial(recipient)
 	if err != nil {
-		return fmt.Errorf("failed to send: %v", err)
+		return err
+	}
+	if !ms.track(conn) {
+		conn.Close()
+		return errClosed
+	}
+	pc := newConn(conn, addr)
+	if ms.opts.Legacy {
+		return ms.sendOnce(recipient, pc, msg, false)
+	}
+
+	features, known := ms.negotiated(addr)
+	if !known {
+		// Assume that the receiver supports routing, so that a
+		// routed message can reach it; see handshake.go.
+		features = FeatureRouting
+	}
+	pc.features = features
+	tooLong := ms.tooLong(msg, features, true)
+	switch {
+	case !known && tooLong && msg.Compression != api.Compression_NONE && ms.tooLong(msg, features, false):
+		// msg fits in a frame only compressed, so the receiver
+		// must say whether it supports compression, even if it
+		// may be a legacy service.
+	case !known || features == 0:
+		// The receiver may be a legacy service, which would
+		// take a bare HANDSHAKE for a message, so msg itself
+		// announces this service, unless it does not fit.
+		return ms.sendOnce(recipient, pc, msg, !tooLong)
+	case tooLong || ms.opts.FlowControl && features&FeatureCredit != 0:
+		// The receiver must answer before msg is sent.
+	case features&FeaturePersistent != 0:
+		return ms.sendPooled(recipient, pc, msg, true)
+	default:
+		return ms.sendOnce(recipient, pc, msg, true)
 	}
 
+	if err := ms.handshake(pc); err != nil {
+		ms.release(pc)
+		return err
+	}
+	if pc.features&FeaturePersistent == 0 {
+		return ms.sendOnce(recipient, pc, msg, false)
+	}
+	return ms.sendPooled(recipient, pc, msg, false)
+}
+
+// sendOnce sends msg to recipient as the last frame on pc, and waits
+// for the receiver to close the connection or refuse it.  announce is
+// set if msg is the first frame on the connection, and announces this
+// service.
+func (ms *messageService) sendOnce(recipient string, pc *pooledConn, msg *api.Message, announce bool) error {
+	defer ms.release(pc)
+	if ms.opts.FlowControl && pc.features&FeatureCredit != 0 && pc.credit == 0 {
+		return &ThrottledError{recipient, "recipient granted no credit", 0}
+	}
+	datas, err := ms.encode(msg, pc.features, announce)
+	if err != nil {
+		return err
+	}
+	if err := pc.write(datas); err != nil {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	return ms.awaitReply(recipient, pc, announce)
+}
+
+// awaitReply waits, for at most the reply timeout, for the recipient
+// on the other end of pc to close the connection or to refuse the
+// message that was just written to it.  It returns the refusal in the
+// latter case, and ErrReplyTimeout if the recipient does neither in
+// time.  If the message announced this service, the
+// recipient's HANDSHAKE reply, or its absence, tells which features
+// it supports.
+func (ms *messageService) awaitReply(recipient string, pc *pooledConn, announced bool) error {
+	// Signal that no more messages are coming, so that the
+	// recipient closes the connection once it has dealt with
+	// this one.
+	closeWrite(pc.conn)
+	pc.conn.SetReadDeadline(time.Now().Add(ms.opts.ReplyTimeout))
+
+	// EOF means the recipient accepted the message.  Anything
+	// else leaves it unknown whether it did.
+	for {
+		reply, err := pc.fr.readFrame()
+		switch {
+		case err == io.EOF:
+			if announced {
+				// Only a legacy receiver closes the
+				// connection without a HANDSHAKE.
+				ms.setNegotiated(pc.addr, 0)
+			}
+			return nil
+		case isTimeout(err):
+			return ErrReplyTimeout
+		case err != nil:
+			return fmt.Errorf("failed to read reply: %w", err)
+		}
+		if reply.Kind == api.Kind_HANDSHAKE {
+			ms.negotiate(pc, reply)
+			announced = false
+			continue
+		}
+		return refusal(recipient, reply)
+	}
+}
+
+// refusal returns the error for a refusal frame sent back by
+// recipient, or nil if reply is not one.
+func refusal(recipient string, reply *api.Message) error {
+	if reply == nil {
+		return nil
+	}
+	switch reply.Kind {
+	case api.Kind_ERROR:
+		return &api.MessageRejected{Msg: reply.Error, Temporary: reply.Temporary}
+	case api.Kind_THROTTLED:
+		return &ThrottledError{recipient, reply.Error, time.Duration(reply.RetryAfter)}
+	}
 	return nil
 }
+
+// isClosed reports whether Close has been called.
+func (ms *messageService) isClosed() bool {
+	select {
+	case <-ms.done:
+		return true
+	default:
+		return false
+	}
+}
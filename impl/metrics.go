@@ -0,0 +1,144 @@
+package impl
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl/metrics"
+)
+
+// instruments records a service's metrics in Options.Metrics.  Every
+// series carries a service label, so that several services can share
+// a registry.  Its methods do nothing if no registry is configured.
+type instruments struct {
+	id string
+	// known reports whether a sender is in the directory.  Other
+	// senders share one peer label, since anyone can claim any
+	// ID.
+	known func(id string) bool
+
+	sent          *metrics.CounterVec
+	sentBytes     *metrics.CounterVec
+	received      *metrics.CounterVec
+	receivedBytes *metrics.CounterVec
+	sendErrors    *metrics.CounterVec
+	receiveErrors *metrics.CounterVec
+	dial          *metrics.HistogramVec
+	gauges        []*metrics.GaugeVec
+}
+
+// unknownPeer is the peer label of senders that are not in the
+// directory.
+const unknownPeer = "unknown"
+
+// newInstruments registers the metrics of ms in reg, which may be nil.
+func newInstruments(ms *messageService, reg *metrics.Registry) *instruments {
+	if reg == nil {
+		return nil
+	}
+	in := &instruments{
+		id:            ms.id,
+		known:         func(id string) bool { _, ok := ms.lookups.lookup(id); return ok },
+		sent:          reg.Counter("messageservice_messages_sent_total", "Messages sent, by recipient.", "service", "peer"),
+		sentBytes:     reg.Counter("messageservice_sent_bytes_total", "Bytes of message data sent, by recipient.", "service", "peer"),
+		received:      reg.Counter("messageservice_messages_received_total", "Messages queued for the application, by sender in the directory.", "service", "peer"),
+		receivedBytes: reg.Counter("messageservice_received_bytes_total", "Bytes of message data queued for the application, by sender in the directory.", "service", "peer"),
+		sendErrors:    reg.Counter("messageservice_send_errors_total", "Sends that failed, by type of error.", "service", "type"),
+		receiveErrors: reg.Counter("messageservice_receive_errors_total", "Errors reported while receiving, by kind.", "service", "kind"),
+		dial:          reg.Histogram("messageservice_dial_seconds", "Time taken to connect to a recipient.", nil, "service"),
+	}
+	active := reg.Gauge("messageservice_active_connections", "Incoming connections being handled.", "service")
+	active.Func(func() float64 { return float64(ms.active.Load()) }, ms.id)
+	depth := reg.Gauge("messageservice_receive_queue_depth", "Messages waiting in the receive queue.", "service")
+	depth.Func(func() float64 { return float64(len(ms.receiver)) }, ms.id)
+	in.gauges = []*metrics.GaugeVec{active, depth}
+	return in
+}
+
+// send records the outcome of sending msg.
+func (in *instruments) send(msg *api.Message, err error) {
+	if in == nil {
+		return
+	}
+	if err != nil {
+		in.sendErrors.With(in.id, sendErrorType(err)).Inc()
+		return
+	}
+	in.sent.With(in.id, msg.Recipient).Inc()
+	in.sentBytes.With(in.id, msg.Recipient).Add(float64(len(msg.Data)))
+}
+
+// receive records the delivery of msg to the receive queue.
+func (in *instruments) receive(msg *api.Message) {
+	if in == nil {
+		return
+	}
+	peer := msg.Sender
+	if !in.known(peer) {
+		peer = unknownPeer
+	}
+	in.received.With(in.id, peer).Inc()
+	in.receivedBytes.With(in.id, peer).Add(float64(len(msg.Data)))
+}
+
+// receiveError records an error reported by reportError.
+func (in *instruments) receiveError(kind ErrorKind) {
+	if in == nil {
+		return
+	}
+	in.receiveErrors.With(in.id, kind.String()).Inc()
+}
+
+// dialed records a connection attempt that began at start.
+func (in *instruments) dialed(start time.Time) {
+	if in == nil {
+		return
+	}
+	in.dial.With(in.id).Observe(time.Since(start).Seconds())
+}
+
+// close stops reporting the gauges, which refer to the service.
+func (in *instruments) close() {
+	if in == nil {
+		return
+	}
+	for _, gauge := range in.gauges {
+		gauge.Delete(in.id)
+	}
+}
+
+// sendErrorType classifies an error returned by Send for the
+// send_errors metric.
+func sendErrorType(err error) string {
+	var rejected *api.MessageRejected
+	var tooLong *api.MessageTooLong
+	var throttled *ThrottledError
+	var unsupported *UnsupportedError
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errClosed):
+		return "closed"
+	case errors.Is(err, ErrUnknownRecipient):
+		return "unknown_recipient"
+	case errors.Is(err, ErrRoutingLoop), errors.Is(err, ErrHopLimit):
+		return "routing"
+	case errors.As(err, &rejected):
+		return "rejected"
+	case errors.As(err, &tooLong):
+		return "too_long"
+	case errors.As(err, &throttled):
+		return "throttled"
+	case errors.As(err, &unsupported):
+		return "unsupported"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "connect"
+	case errors.As(err, &opErr):
+		return "io"
+	}
+	return "other"
+}
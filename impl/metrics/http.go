@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+)
+
+// ContentType is the media type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an http.Handler that serves the metrics in r.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+// Serve listens on addr, which should normally be a loopback address
+// such as "localhost:9100", and serves the metrics in r at /metrics
+// until the returned server is closed.
+func (r *Registry) Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return server, nil
+}
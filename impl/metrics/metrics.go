@@ -0,0 +1,335 @@
+// Package metrics is a small, dependency-free metrics registry that
+// exposes counters, gauges, and histograms in the Prometheus text
+// exposition format, optionally over HTTP.
+//
+// Metrics are created through a Registry, which returns the existing
+// metric if one of the same name has already been created.  Each
+// metric is a family of series distinguished by label values given
+// to With.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are histogram buckets suited to network latencies in
+// seconds.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric and its series, keyed by their label values.
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is one set of label values of a family.  Exactly one of
+// its value fields is used, according to the family's type.
+type series struct {
+	values []string
+	bits   atomic.Uint64  // float64 bits of a counter or gauge
+	fn     func() float64 // guarded by the family's mu
+	hist   *histogram
+}
+
+// family returns the family called name, creating it if necessary.
+// It panics if the name is already used for a different kind of
+// metric, since that is a programming error.
+func (r *Registry) family(name, help, typ string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s redefined", name))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series with the given label values, creating it
+// if necessary.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.typ == "histogram" {
+			s.hist = &histogram{counts: make([]uint64, len(f.buckets))}
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// remove deletes the series with the given label values.
+func (f *family) remove(values []string) {
+	f.mu.Lock()
+	delete(f.series, strings.Join(values, "\xff"))
+	f.mu.Unlock()
+}
+
+// add adds v to the float64 held in bits.
+func add(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct{ f *family }
+
+// Counter is a value that only increases.
+type Counter struct{ s *series }
+
+// Counter returns the counter called name with the given labels.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.family(name, help, "counter", nil, labels)}
+}
+
+// With returns the counter with the given label values.
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{v.f.with(values)}
+}
+
+// Inc adds one to c.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v, which must not be negative, to c.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter decreased")
+	}
+	add(&c.s.bits, v)
+}
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct{ f *family }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ s *series }
+
+// Gauge returns the gauge called name with the given labels.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.family(name, help, "gauge", nil, labels)}
+}
+
+// With returns the gauge with the given label values.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{v.f.with(values)}
+}
+
+// Func makes the gauge with the given label values report the
+// result of calling fn each time the metrics are written.
+func (v *GaugeVec) Func(fn func() float64, values ...string) {
+	s := v.f.with(values)
+	v.f.mu.Lock()
+	s.fn = fn
+	v.f.mu.Unlock()
+}
+
+// Delete removes the gauge with the given label values, so that it
+// is no longer reported.
+func (v *GaugeVec) Delete(values ...string) {
+	v.f.remove(values)
+}
+
+// Set sets g to x.
+func (g *Gauge) Set(x float64) { g.s.bits.Store(math.Float64bits(x)) }
+
+// Add adds x to g.
+func (g *Gauge) Add(x float64) { add(&g.s.bits, x) }
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct{ f *family }
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// histogram holds the counts of a Histogram.  counts[i] is the number
+// of observations no greater than the family's buckets[i] and greater
+// than the bucket before it.
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram returns the histogram called name with the given upper
+// bucket bounds, which must be sorted, and labels.  If buckets is
+// nil, DefBuckets are used.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{r.family(name, help, "histogram", buckets, labels)}
+}
+
+// With returns the histogram with the given label values.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{v.f.with(values), v.f.buckets}
+}
+
+// Observe records x in h.
+func (h *Histogram) Observe(x float64) {
+	hist := h.s.hist
+	i := sort.SearchFloat64s(h.buckets, x)
+	hist.mu.Lock()
+	if i < len(hist.counts) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += x
+	hist.mu.Unlock()
+}
+
+// WriteTo writes every metric in r to w in the Prometheus text
+// exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// write appends f to b.
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	fns := make(map[*series]func() float64)
+	for _, s := range f.series {
+		all = append(all, s)
+		if s.fn != nil {
+			fns[s] = s.fn
+		}
+	}
+	f.mu.Unlock()
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range all {
+		labels := f.labelPairs(s.values)
+		switch {
+		case s.hist != nil:
+			s.hist.mu.Lock()
+			cumulative := uint64(0)
+			for i, le := range f.buckets {
+				cumulative += s.hist.counts[i]
+				fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, withLabel(labels, "le", formatFloat(le)), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, withLabel(labels, "le", "+Inf"), s.hist.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", f.name, braces(labels), formatFloat(s.hist.sum))
+			fmt.Fprintf(b, "%s_count%s %d\n", f.name, braces(labels), s.hist.count)
+			s.hist.mu.Unlock()
+		case fns[s] != nil:
+			fmt.Fprintf(b, "%s%s %s\n", f.name, braces(labels), formatFloat(fns[s]()))
+		default:
+			fmt.Fprintf(b, "%s%s %s\n", f.name, braces(labels), formatFloat(math.Float64frombits(s.bits.Load())))
+		}
+	}
+}
+
+// labelPairs formats values as name="value" pairs.
+func (f *family) labelPairs(values []string) []string {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = f.labels[i] + `="` + escapeValue(v) + `"`
+	}
+	return pairs
+}
+
+// withLabel adds one more label pair and encloses the pairs in
+// braces.
+func withLabel(pairs []string, name, value string) string {
+	return braces(append(append([]string(nil), pairs...), name+`="`+value+`"`))
+}
+
+// braces encloses label pairs in braces, or returns "" if there are
+// none.
+func braces(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeValue(s string) string { return valueEscaper.Replace(s) }
+
+// formatFloat formats v as Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestWriteTo checks the text format of each kind of metric.
+func TestWriteTo(t *testing.T) {
+	reg := NewRegistry()
+	sent := reg.Counter("sent_total", "Messages sent.", "peer")
+	sent.With("alice").Add(2)
+	sent.With(`b"ob`).Inc()
+	// Asking again returns the same counter.
+	reg.Counter("sent_total", "Messages sent.", "peer").With("alice").Inc()
+	depth := reg.Gauge("depth", "Queue\ndepth.")
+	depth.Func(func() float64 { return 7 })
+	gone := reg.Gauge("gone", "Deleted.", "id")
+	gone.With("x").Set(1)
+	gone.Delete("x")
+	rtt := reg.Histogram("rtt_seconds", "Round trips.", []float64{0.1, 1})
+	for _, x := range []float64{0.05, 0.1, 0.5, 3} {
+		rtt.With().Observe(x)
+	}
+
+	want := `# HELP depth Queue\ndepth.
+# TYPE depth gauge
+depth 7
+# HELP rtt_seconds Round trips.
+# TYPE rtt_seconds histogram
+rtt_seconds_bucket{le="0.1"} 2
+rtt_seconds_bucket{le="1"} 3
+rtt_seconds_bucket{le="+Inf"} 4
+rtt_seconds_sum 3.65
+rtt_seconds_count 4
+# HELP sent_total Messages sent.
+# TYPE sent_total counter
+sent_total{peer="alice"} 3
+sent_total{peer="b\"ob"} 1
+`
+	var b strings.Builder
+	reg.WriteTo(&b)
+	if b.String() != want {
+		t.Errorf("WriteTo wrote\n%s\nexpected\n%s", b.String(), want)
+	}
+
+	server := httptest.NewServer(reg.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType || string(body) != want {
+		t.Errorf("Handler served %q with type %q", body, ct)
+	}
+}
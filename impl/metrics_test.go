@@ -0,0 +1,71 @@
+package impl
+
+import (
+	"strings"
+	"testing"
+
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+	"cse586.messageservice/impl/metrics"
+)
+
+// TestMetrics sends a message between two services sharing a
+// registry, one to an unknown recipient, and one from a sender that
+// is not in the directory, and checks the series that they record.
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	bobAddr := impltest.FreeAddr(t)
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": bobAddr})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, Metrics: reg})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir, Metrics: reg, ReceiveBuffer: 4})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+
+	if err := alice.Send("bob", []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := alice.Send("carol", []byte("hello")); err == nil {
+		t.Fatal("Send to an unknown recipient succeeded")
+	}
+	mallory, err := NewMessageServiceWithOptions("mallory", Options{
+		Directory: directory.NewStatic(map[string]string{"mallory": impltest.FreeAddr(t), "bob": bobAddr}),
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer mallory.Close()
+	if err := mallory.Send("bob", []byte("hi")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	for _, want := range []string{
+		`messageservice_messages_sent_total{service="alice",peer="bob"} 1`,
+		`messageservice_sent_bytes_total{service="alice",peer="bob"} 5`,
+		`messageservice_messages_received_total{service="bob",peer="alice"} 1`,
+		`messageservice_received_bytes_total{service="bob",peer="alice"} 5`,
+		`messageservice_messages_received_total{service="bob",peer="unknown"} 1`,
+		`messageservice_send_errors_total{service="alice",type="unknown_recipient"} 1`,
+		`messageservice_dial_seconds_count{service="alice"} 1`,
+		`messageservice_receive_queue_depth{service="bob"} 2`,
+		`messageservice_active_connections{service="alice"} 0`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("Metrics lack %s:\n%s", want, b.String())
+		}
+	}
+
+	// A closed service's gauges are no longer reported.
+	bob.Close()
+	b.Reset()
+	reg.WriteTo(&b)
+	if strings.Contains(b.String(), `queue_depth{service="bob"}`) {
+		t.Errorf("Closed service still reported:\n%s", b.String())
+	}
+}
@@ -0,0 +1,91 @@
+package impl
+
+import (
+	"fmt"
+	"net"
+
+	"cse586.messageservice/api"
+)
+
+// MisaddressedPolicy selects what a MessageService does with an
+// incoming message whose Recipient is not the service's own ID.
+// Every such message is reported as a RecipientMismatch error first.
+type MisaddressedPolicy int
+
+const (
+	// DeliverMisaddressed delivers the message anyway.  It is
+	// the default, for compatibility with peers that do not fill
+	// in the recipient.
+	DeliverMisaddressed MisaddressedPolicy = iota
+	// RejectMisaddressed refuses the message, so that a stream
+	// sender's Send fails with api.MessageRejected.
+	RejectMisaddressed
+	// DropMisaddressed silently discards the message.
+	DropMisaddressed
+	// ForwardMisaddressed looks the recipient up in the
+	// directory and sends the message on to it, making this
+	// service a relay.  The service adds itself to the message's
+	// path, and refuses a message that has already passed
+	// through it or through Options.MaxHops services, so that
+	// services whose directories disagree cannot forward it in a
+	// loop.  If forwarding fails, the message is refused as for
+	// RejectMisaddressed.
+	ForwardMisaddressed
+)
+
+// accept handles a message read from the network, relaying it or
+// applying the misaddressed message policy if it is not for this
+// service.  It returns the reason the message was refused, if it
+// was.  The caller must be registered with begin or track.
+func (ms *messageService) accept(msg *api.Message, remote net.Addr) error {
+	host := remoteHost(remote)
+	if ok, wait := ms.receiveLimit.allow(host); !ok {
+		err := &ThrottledError{host, "receive rate limit exceeded", wait}
+		ms.counters.throttled.Add(1)
+		ms.reportError(RateLimited, remote, err)
+		return err
+	}
+	if msg.Recipient == ms.id {
+		ms.learn(msg)
+		return ms.deliverLocal(msg, remote)
+	}
+	if msg.Ttl > 0 && ms.opts.Relay {
+		return ms.relay(msg, remote)
+	}
+
+	misaddressed := &MisaddressedError{msg.Sender, msg.Recipient}
+	ms.reportError(RecipientMismatch, remote, misaddressed)
+	switch ms.opts.Misaddressed {
+	case RejectMisaddressed:
+		ms.counters.misaddressed.Add(1)
+		return misaddressed
+	case DropMisaddressed:
+		ms.counters.misaddressed.Add(1)
+		return nil
+	case ForwardMisaddressed:
+		if err := ms.forward(msg); err != nil {
+			ms.counters.misaddressed.Add(1)
+			ms.reportError(ForwardFailed, remote, err)
+			return err
+		}
+		ms.counters.forwarded.Add(1)
+		return nil
+	}
+	return ms.deliverLocal(msg, remote)
+}
+
+// forward sends msg on to its recipient, adding this service to its
+// path.
+func (ms *messageService) forward(msg *api.Message) error {
+	if msg.Recipient == "" {
+		return fmt.Errorf("cannot forward message from %q with no recipient", msg.Sender)
+	}
+	if err := ms.checkHops(msg); err != nil {
+		return err
+	}
+	msg.Path = append(msg.Path, ms.id)
+	if err := ms.transmit(msg.Recipient, msg); err != nil {
+		return fmt.Errorf("forwarding to %s: %v", msg.Recipient, err)
+	}
+	return nil
+}
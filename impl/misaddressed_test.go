@@ -0,0 +1,123 @@
+package impl
+
+import (
+	"errors"
+	"testing"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestMisaddressedPolicies has alice send to carol through a
+// directory that gives carol bob's address, and checks what bob does
+// with the message under each policy.
+func TestMisaddressedPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   MisaddressedPolicy
+		rejected bool
+		stats    Stats
+	}{
+		{"Deliver", DeliverMisaddressed, false, Stats{Received: 1}},
+		{"Reject", RejectMisaddressed, true, Stats{Misaddressed: 1}},
+		{"Drop", DropMisaddressed, false, Stats{Misaddressed: 1}},
+		{"ForwardUnknown", ForwardMisaddressed, true, Stats{Misaddressed: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bobAddr := impltest.FreeAddr(t)
+			bob, err := NewMessageServiceWithOptions("bob", Options{
+				Directory:    directory.NewStatic(map[string]string{"bob": bobAddr}),
+				Misaddressed: tc.policy,
+			})
+			if err != nil {
+				t.Fatalf("Could not create service: %v", err)
+			}
+			defer bob.Close()
+			alice, err := NewMessageServiceWithOptions("alice", Options{
+				Directory: directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "carol": bobAddr}),
+			})
+			if err != nil {
+				t.Fatalf("Could not create service: %v", err)
+			}
+			defer alice.Close()
+
+			err = alice.Send("carol", []byte("hello"))
+			var rej *api.MessageRejected
+			if got := errors.As(err, &rej); got != tc.rejected || got && rej.Temporary {
+				t.Errorf("Send returned %v", err)
+			}
+			if stats := bob.Stats(); stats != tc.stats {
+				t.Errorf("Stats = %+v, expected %+v", stats, tc.stats)
+			}
+		})
+	}
+}
+
+// TestForwardMisaddressed ensures that a relay forwards a message
+// to its real recipient with the sender and recipient unchanged and
+// the relay added to its path.
+func TestForwardMisaddressed(t *testing.T) {
+	relayAddr, carolAddr := impltest.FreeAddr(t), impltest.FreeAddr(t)
+	relay, err := NewMessageServiceWithOptions("relay", Options{
+		Directory:    directory.NewStatic(map[string]string{"relay": relayAddr, "carol": carolAddr}),
+		Misaddressed: ForwardMisaddressed,
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer relay.Close()
+	carol, err := NewMessageServiceWithOptions("carol", Options{
+		Directory: directory.NewStatic(map[string]string{"carol": carolAddr}),
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer carol.Close()
+	alice, err := NewMessageServiceWithOptions("alice", Options{
+		Directory: directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "carol": relayAddr}),
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+
+	if err := alice.Send("carol", []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if rmsg := <-carol.Receiver(); rmsg.Sender != "alice" || rmsg.Recipient != "carol" || string(rmsg.Data) != "hello" ||
+		len(rmsg.Path) != 1 || rmsg.Path[0] != "relay" {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+	if stats := relay.Stats(); stats.Forwarded != 1 || stats.Received != 0 {
+		t.Errorf("Relay stats = %+v", stats)
+	}
+}
+
+// TestForwardLoop ensures that relays whose directories point at each
+// other refuse a message rather than forwarding it in a loop.
+func TestForwardLoop(t *testing.T) {
+	addrs := map[string]string{"one": impltest.FreeAddr(t), "two": impltest.FreeAddr(t)}
+	for id, other := range map[string]string{"one": "two", "two": "one"} {
+		relay, err := NewMessageServiceWithOptions(id, Options{
+			Directory:    directory.NewStatic(map[string]string{id: addrs[id], "carol": addrs[other]}),
+			Misaddressed: ForwardMisaddressed,
+		})
+		if err != nil {
+			t.Fatalf("Could not create service: %v", err)
+		}
+		defer relay.Close()
+	}
+	alice, err := NewMessageServiceWithOptions("alice", Options{
+		Directory: directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "carol": addrs["one"]}),
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+
+	var rejected *api.MessageRejected
+	if err := alice.Send("carol", []byte("hello")); !errors.As(err, &rejected) {
+		t.Errorf("Send returned %v, expected a rejection", err)
+	}
+}
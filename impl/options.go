@@ -0,0 +1,294 @@
+package impl
+
+import (
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/given/logging"
+	"cse586.messageservice/impl/metrics"
+	"cse586.messageservice/impl/trace"
+)
+
+// DefaultReceiveBuffer is the receive channel capacity used when
+// Options.ReceiveBuffer is zero.
+const DefaultReceiveBuffer = 64
+
+// DefaultReplyTimeout is the reply timeout used when
+// Options.ReplyTimeout is zero.
+const DefaultReplyTimeout = time.Second
+
+// Transport selects the kind of socket a MessageService uses.
+type Transport int
+
+const (
+	// StreamTransport sends each message over a TCP or Unix
+	// stream connection.  It is the default.
+	StreamTransport Transport = iota
+	// DatagramTransport sends each message as a single UDP or
+	// Unix datagram.  Delivery is unreliable, and both ends of a
+	// conversation must use it.
+	DatagramTransport
+)
+
+// Options configures a MessageService created with
+// NewMessageServiceWithOptions.  The zero value is valid and gives
+// the same behavior as NewMessageService.
+type Options struct {
+	// Directory resolves IDs to addresses, both for this
+	// service's own listen address and for recipients.  If nil,
+	// directory.Default is used.
+	Directory directory.Directory
+
+	// LookupTTL is how long a recipient's address is cached
+	// after it is looked up.  Zero selects DefaultLookupTTL, and
+	// a negative value disables caching.  Cached addresses are
+	// refreshed early if the directory reports a change or a
+	// connection to the address fails.
+	LookupTTL time.Duration
+
+	// FallbackDelay is how long Send waits for a connection to
+	// one of a recipient's addresses before also trying the next.
+	// Zero selects DefaultFallbackDelay.
+	FallbackDelay time.Duration
+
+	// Transport selects stream or datagram sockets.  The
+	// directory addresses of a datagram service are used as UDP
+	// addresses (or unixgram paths) rather than TCP addresses.
+	Transport Transport
+
+	// ReceiveBuffer is the capacity of the channel returned by
+	// Receiver.  Zero selects DefaultReceiveBuffer, and a
+	// negative value gives an unbuffered channel.
+	ReceiveBuffer int
+
+	// Backpressure selects what happens to incoming messages
+	// when the receive channel is full.  The zero value is
+	// Block.
+	Backpressure Backpressure
+
+	// ReplyTimeout is how long a stream Send waits, after
+	// writing a message, for the recipient to acknowledge it, to
+	// close the connection, or to refuse the message with an
+	// error frame.  If it does none of these, Send returns
+	// ErrReplyTimeout, although the message may still be
+	// delivered.  Zero selects DefaultReplyTimeout.
+	ReplyTimeout time.Duration
+
+	// CloseTimeout bounds how long Close waits for connection
+	// handlers to finish.  Zero selects DefaultCloseTimeout.
+	CloseTimeout time.Duration
+
+	// DrainOnClose leaves messages that are already queued when
+	// Close is called on the Receiver channel, so that the
+	// application can still read them.  Otherwise they are
+	// discarded.
+	DrainOnClose bool
+
+	// OnError, if not nil, is called with each *ReceiveError
+	// that is also reported on the Errors channel.  It is called
+	// from the goroutine handling the connection, so it should
+	// not block.
+	OnError func(error)
+
+	// Misaddressed selects what happens to incoming messages
+	// addressed to another recipient.  The zero value is
+	// DeliverMisaddressed.
+	Misaddressed MisaddressedPolicy
+
+	// Routes maps recipient IDs to the ID of a relay through
+	// which messages for them are sent, for recipients that this
+	// service cannot connect to directly.  The DefaultRoute key
+	// names a relay to try for any recipient that cannot be
+	// reached otherwise.  Routes are also learned from routed
+	// messages that arrive through relays.
+	Routes map[string]string
+
+	// Relay makes this service pass on routed messages that are
+	// addressed to other services, rather than treating them as
+	// misaddressed.
+	Relay bool
+
+	// MaxHops is the number of hops a message sent through a
+	// route may take, so one more than the number of relays it
+	// may pass through.  Zero selects DefaultMaxHops.
+	MaxHops int
+
+	// Compression is the algorithm with which Send compresses
+	// message data of at least CompressThreshold bytes.  Data
+	// that does not shrink is sent uncompressed.  The zero value,
+	// api.Compression_NONE, disables compression; received
+	// messages are decompressed regardless.
+	Compression api.Compression
+
+	// CompressThreshold is the smallest data length that Send
+	// compresses.  Zero selects DefaultCompressThreshold.
+	CompressThreshold int
+
+	// MaxDecompressedLen bounds the length of decompressed
+	// message data.  Messages that would exceed it are refused.
+	// Zero selects DefaultMaxDecompressedLen.
+	MaxDecompressedLen int
+
+	// Legacy makes the service speak only the original protocol,
+	// without handshakes or optional features, as if it were a
+	// service that predates them.
+	Legacy bool
+
+	// DisableFeatures lists protocol features that the service
+	// does not offer to its peers during the handshake.
+	DisableFeatures Feature
+
+	// HandshakeTimeout is how long Send waits for the reply to
+	// a bare HANDSHAKE frame, when it must know a recipient's
+	// features before sending, before assuming that it is a
+	// legacy service.  Zero selects DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+
+	// Priority is the priority of messages sent with Send, and
+	// of messages sent with SendMessage that do not set one.  The
+	// zero value is api.Priority_NORMAL.
+	Priority api.Priority
+
+	// StarvationLimit is the number of messages that may be sent
+	// to a recipient ahead of a waiting lower-priority message
+	// before that message is sent.  Zero selects
+	// DefaultStarvationLimit.
+	StarvationLimit int
+
+	// PoolIdleTimeout is how long a connection to a recipient
+	// that supports persistent connections is kept open, unused,
+	// for later messages.  Zero selects DefaultPoolIdleTimeout,
+	// and a negative value closes each connection after one
+	// message.
+	PoolIdleTimeout time.Duration
+
+	// MaxSendsPerPeer bounds the number of messages sent to one
+	// recipient at once.  Further messages wait, in priority
+	// order, except that a CONTROL message is sent at once.  Zero
+	// selects DefaultMaxSendsPerPeer, and a negative value is no
+	// limit.
+	MaxSendsPerPeer int
+
+	// SendRate limits the rate at which Send sends messages to
+	// each recipient.  Messages over the limit are not sent, and
+	// Send returns a ThrottledError.
+	SendRate Rate
+
+	// ReceiveRate limits the rate at which messages are accepted
+	// from each remote host, whatever sender IDs its messages
+	// carry.  Messages over the limit are refused, so that a
+	// stream sender's Send fails with a ThrottledError saying
+	// when to retry, or with api.MessageRejected if the sender is
+	// a legacy service.
+	ReceiveRate Rate
+
+	// FlowControl makes Send respect the credit that a recipient
+	// grants on each connection, which is the free space in its
+	// receive queue, renewed as each message is accepted.  A
+	// message for a recipient with no credit is not sent, and
+	// Send returns a ThrottledError.
+	FlowControl bool
+
+	// MaxConnections bounds the number of incoming connections
+	// handled at once.  Connections that arrive while it is
+	// reached are closed at once and reported.  Zero selects
+	// DefaultMaxConnections, and a negative value is no limit.
+	MaxConnections int
+
+	// IdleTimeout bounds how long an incoming connection may
+	// wait between frames, and ReadTimeout how long it may take
+	// to deliver the rest of a frame once it has begun.  A
+	// connection that exceeds either is closed.  Zero selects
+	// DefaultIdleTimeout or DefaultReadTimeout, and a negative
+	// value is no limit.
+	IdleTimeout time.Duration
+	ReadTimeout time.Duration
+
+	// MaxFramesPerConnection bounds the number of frames read
+	// from an incoming connection before it is closed.  Zero
+	// selects DefaultMaxFramesPerConnection, and a negative value
+	// is no limit.
+	MaxFramesPerConnection int
+
+	// Metrics, if not nil, is the registry in which the service
+	// records its metrics.  See package metrics.
+	Metrics *metrics.Registry
+
+	// Tracer, if not nil, records a span for each message sent
+	// and each message delivered to the receive queue, and
+	// propagates trace context in the trace.Header header of
+	// messages.  See package trace.
+	Tracer *trace.Tracer
+
+	// Logger, if not nil, receives events such as connections
+	// opening and closing and failures to send or receive.
+	// Connections are logged at debug level and failures at warn
+	// level.  Every event has a service attribute.
+	Logger logging.Logger
+
+	// ErrorBuffer is the capacity of the channel returned by
+	// Errors.  Zero selects DefaultErrorBuffer.
+	ErrorBuffer int
+}
+
+// withDefaults returns a copy of opts with unset fields filled in.
+func (opts Options) withDefaults() Options {
+	if opts.Directory == nil {
+		opts.Directory = directory.Default
+	}
+	if opts.LookupTTL == 0 {
+		opts.LookupTTL = DefaultLookupTTL
+	}
+	if opts.FallbackDelay == 0 {
+		opts.FallbackDelay = DefaultFallbackDelay
+	}
+	if opts.ReceiveBuffer == 0 {
+		opts.ReceiveBuffer = DefaultReceiveBuffer
+	} else if opts.ReceiveBuffer < 0 {
+		opts.ReceiveBuffer = 0
+	}
+	if opts.ReplyTimeout == 0 {
+		opts.ReplyTimeout = DefaultReplyTimeout
+	}
+	if opts.ErrorBuffer <= 0 {
+		opts.ErrorBuffer = DefaultErrorBuffer
+	}
+	if opts.CompressThreshold <= 0 {
+		opts.CompressThreshold = DefaultCompressThreshold
+	}
+	if opts.MaxDecompressedLen <= 0 {
+		opts.MaxDecompressedLen = DefaultMaxDecompressedLen
+	}
+	if opts.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if opts.StarvationLimit <= 0 {
+		opts.StarvationLimit = DefaultStarvationLimit
+	}
+	if opts.PoolIdleTimeout == 0 {
+		opts.PoolIdleTimeout = DefaultPoolIdleTimeout
+	}
+	if opts.MaxSendsPerPeer == 0 {
+		opts.MaxSendsPerPeer = DefaultMaxSendsPerPeer
+	}
+	if opts.MaxHops <= 0 {
+		opts.MaxHops = DefaultMaxHops
+	}
+	if opts.MaxConnections == 0 {
+		opts.MaxConnections = DefaultMaxConnections
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = DefaultReadTimeout
+	}
+	if opts.MaxFramesPerConnection == 0 {
+		opts.MaxFramesPerConnection = DefaultMaxFramesPerConnection
+	}
+	if opts.CloseTimeout == 0 {
+		opts.CloseTimeout = DefaultCloseTimeout
+	}
+	return opts
+}
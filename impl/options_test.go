@@ -0,0 +1,50 @@
+package impl
+
+import (
+	"bytes"
+	"testing"
+
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// start returns a function for impltest.Start that creates services
+// on dir with opts.
+func start(dir directory.Directory, opts Options) func(id string) (Service, error) {
+	opts.Directory = dir
+	return func(id string) (Service, error) {
+		return NewMessageServiceWithOptions(id, opts)
+	}
+}
+
+// TestStaticDirectorySend creates two services on a Static directory
+// and ensures that a message sent from one arrives at the other.
+func TestStaticDirectorySend(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{
+		"alice": impltest.FreeAddr(t),
+		"bob":   impltest.FreeAddr(t),
+	})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	if err := alice.Send("bob", staticMsgText[:]); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	rmsg := <-bob.Receiver()
+	if rmsg.Sender != "alice" || rmsg.Recipient != "bob" ||
+		!bytes.Equal(rmsg.Data, staticMsgText[:]) {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+
+	if err := alice.Send("carol", nil); err == nil {
+		t.Error("Send to unknown ID succeeded")
+	}
+}
@@ -0,0 +1,412 @@
+// Package outbox provides store-and-forward delivery on top of an
+// impl.Service.  Messages sent through an Outbox are appended to a
+// write-ahead log before Send returns, and are delivered in the
+// background, with retries, until the recipient accepts them.  The
+// log survives restarts: opening an Outbox on an existing log resumes
+// delivery of the messages in it that were not yet delivered.
+//
+// Delivery is at least once.  A message whose delivery was not
+// recorded before a crash is sent again after the restart.
+//
+// Only failures that may clear are retried, such as a recipient that
+// cannot be reached or whose receive queue is full.  A message that
+// fails in a way that sending it again would not change, because its
+// recipient is unknown or refused it for good, is removed from the
+// log and reported to Options.OnFailure.
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl"
+)
+
+// DefaultRetryInterval is the first retry delay used when
+// Options.RetryInterval is zero.
+const DefaultRetryInterval = 100 * time.Millisecond
+
+// DefaultMaxRetryInterval is the longest retry delay used when
+// Options.MaxRetryInterval is zero.
+const DefaultMaxRetryInterval = 30 * time.Second
+
+// DefaultCompactThreshold is the compaction threshold used when
+// Options.CompactThreshold is zero.
+const DefaultCompactThreshold = 1024
+
+// ErrClosed is returned by Send after Close.
+var ErrClosed = errors.New("outbox closed")
+
+// Options configures an Outbox.  The zero value is valid.
+type Options struct {
+	// RetryInterval is how long delivery to a recipient waits
+	// after its first failure.  The delay doubles with each
+	// further failure, up to MaxRetryInterval.  Zero selects
+	// DefaultRetryInterval.
+	RetryInterval time.Duration
+
+	// MaxRetryInterval bounds the retry delay.  Zero selects
+	// DefaultMaxRetryInterval.
+	MaxRetryInterval time.Duration
+
+	// CompactThreshold is the number of delivered messages after
+	// which the log is rewritten to hold only the undelivered
+	// ones.  Zero selects DefaultCompactThreshold.
+	CompactThreshold int
+
+	// NoSync skips syncing the log to disk after each write.
+	// Messages may then be lost if the machine crashes, but not
+	// if only the process does.
+	NoSync bool
+
+	// OnFailure, if not nil, is called with each message that is
+	// given up on, and the error that its last delivery attempt
+	// returned.  It is called from the goroutine that delivers
+	// messages, so it should not block.
+	OnFailure func(recipient string, data []byte, err error)
+}
+
+// Outbox is an api.MessageService whose Send stores messages for
+// delivery through another MessageService.
+type Outbox struct {
+	ms   impl.Service
+	opts Options
+	path string
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu        sync.Mutex
+	f         *os.File
+	queues    map[string]*queue
+	nextSeq   uint64
+	delivered int
+	closed    bool
+}
+
+// queue holds the undelivered messages for one recipient, oldest
+// first.
+type queue struct {
+	entries []*entry
+	retryAt time.Time
+	backoff time.Duration
+}
+
+// Open creates an Outbox that delivers through ms, logging to the
+// file at path, which is created if necessary.  Undelivered messages
+// already in the log are queued for delivery.  The Outbox owns ms from
+// then on, and closes it when it is closed, or at once if Open fails.
+func Open(ms impl.Service, path string, opts Options) (_ *Outbox, err error) {
+	defer func() {
+		if err != nil {
+			ms.Close()
+		}
+	}()
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultRetryInterval
+	}
+	if opts.MaxRetryInterval <= 0 {
+		opts.MaxRetryInterval = DefaultMaxRetryInterval
+	}
+	if opts.CompactThreshold <= 0 {
+		opts.CompactThreshold = DefaultCompactThreshold
+	}
+
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	pending, maxSeq, err := replay(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		ms:      ms,
+		opts:    opts,
+		path:    path,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		queues:  make(map[string]*queue),
+		nextSeq: maxSeq + 1,
+	}
+	for _, e := range pending {
+		o.queue(e.recipient).entries = append(o.queue(e.recipient).entries, e)
+	}
+	// Rewriting the log drops delivered messages and any torn
+	// record at its end.
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+
+	o.wg.Add(1)
+	go o.run()
+	return o, nil
+}
+
+// queue returns the queue for recipient, creating it if necessary.
+// The caller must hold o.mu.
+func (o *Outbox) queue(recipient string) *queue {
+	q, ok := o.queues[recipient]
+	if !ok {
+		q = &queue{}
+		o.queues[recipient] = q
+	}
+	return q
+}
+
+// Receiver returns the underlying service's Receiver channel.
+func (o *Outbox) Receiver() <-chan *api.Message {
+	return o.ms.Receiver()
+}
+
+// Send logs a message to recipient for delivery and returns.  An
+// error means that the message was not logged, as when it is too long
+// for the underlying service to send; delivery failures are retried
+// or reported to Options.OnFailure.
+func (o *Outbox) Send(recipient string, data []byte) error {
+	if len(recipient) > 0xffff {
+		return &api.MessageTooLong{Msg: fmt.Sprintf("Recipient is %d bytes", len(recipient))}
+	}
+	if err := o.ms.CheckSend(recipient, data); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ErrClosed
+	}
+	e := &entry{o.nextSeq, recipient, append([]byte(nil), data...)}
+	if err := o.write(appendEntry(nil, e)); err != nil {
+		return err
+	}
+	o.nextSeq++
+	q := o.queue(recipient)
+	q.entries = append(q.entries, e)
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pending returns the number of messages not yet delivered.
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, q := range o.queues {
+		n += len(q.entries)
+	}
+	return n
+}
+
+// Close stops delivery, closes the log, and closes the underlying
+// service.  Undelivered messages remain in the log.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	close(o.done)
+	o.mu.Unlock()
+
+	// Closing the service first interrupts a delivery in
+	// progress.
+	err := o.ms.Close()
+	o.wg.Wait()
+	if ferr := o.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// write appends rec to the log.  The caller must hold o.mu.
+func (o *Outbox) write(rec []byte) error {
+	if _, err := o.f.Write(rec); err != nil {
+		return fmt.Errorf("outbox log: %v", err)
+	}
+	if !o.opts.NoSync {
+		if err := o.f.Sync(); err != nil {
+			return fmt.Errorf("outbox log: %v", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log to hold only the undelivered messages.
+// The new log is written beside the old one and renamed over it, so
+// that a crash leaves one or the other intact.  The caller must hold
+// o.mu or have sole access to o.
+func (o *Outbox) compact() error {
+	var entries []*entry
+	for _, q := range o.queues {
+		entries = append(entries, q.entries...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	var b []byte
+	for _, e := range entries {
+		b = appendEntry(b, e)
+	}
+
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if !o.opts.NoSync {
+		if f, err := os.Open(tmp); err == nil {
+			f.Sync()
+			f.Close()
+		}
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if o.f != nil {
+		o.f.Close()
+	}
+	o.f = f
+	o.delivered = 0
+	return nil
+}
+
+// run delivers queued messages until the Outbox is closed.
+func (o *Outbox) run() {
+	defer o.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		next := o.deliverDue()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var wait <-chan time.Time
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+			wait = timer.C
+		}
+		select {
+		case <-o.done:
+			return
+		case <-o.wake:
+		case <-wait:
+		}
+	}
+}
+
+// deliverDue sends the queued messages of every recipient that is
+// not waiting to retry, in order, stopping at each recipient's first
+// failure.  It returns the time of the next retry, or the zero time
+// if none is needed.
+func (o *Outbox) deliverDue() time.Time {
+	o.mu.Lock()
+	var due []string
+	now := time.Now()
+	for recipient, q := range o.queues {
+		if len(q.entries) > 0 && !now.Before(q.retryAt) {
+			due = append(due, recipient)
+		}
+	}
+	o.mu.Unlock()
+	sort.Strings(due)
+
+	for _, recipient := range due {
+		o.deliverTo(recipient)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var next time.Time
+	for recipient, q := range o.queues {
+		if len(q.entries) == 0 {
+			delete(o.queues, recipient)
+			continue
+		}
+		if next.IsZero() || q.retryAt.Before(next) {
+			next = q.retryAt
+		}
+	}
+	return next
+}
+
+// deliverTo sends recipient's queued messages until the queue is
+// empty, a send fails in a way that may clear, or the Outbox is
+// closed.  Messages that fail permanently are dropped and reported.
+func (o *Outbox) deliverTo(recipient string) {
+	for {
+		o.mu.Lock()
+		q := o.queues[recipient]
+		if o.closed || len(q.entries) == 0 {
+			o.mu.Unlock()
+			return
+		}
+		e := q.entries[0]
+		o.mu.Unlock()
+
+		err := o.ms.Send(e.recipient, e.data)
+
+		o.mu.Lock()
+		if err != nil && !permanent(err) {
+			q.backoff *= 2
+			if q.backoff < o.opts.RetryInterval {
+				q.backoff = o.opts.RetryInterval
+			}
+			if q.backoff > o.opts.MaxRetryInterval {
+				q.backoff = o.opts.MaxRetryInterval
+			}
+			q.retryAt = time.Now().Add(q.backoff)
+			o.mu.Unlock()
+			return
+		}
+		q.entries = q.entries[1:]
+		q.backoff = 0
+		q.retryAt = time.Time{}
+		// If the delivery cannot be recorded, the message
+		// will be sent again after a restart.
+		o.write(appendRecord(nil, recDelivered, e.seq, nil))
+		o.delivered++
+		if o.delivered >= o.opts.CompactThreshold {
+			o.compact()
+		}
+		o.mu.Unlock()
+
+		if err != nil && o.opts.OnFailure != nil {
+			o.opts.OnFailure(e.recipient, e.data, err)
+		}
+	}
+}
+
+// permanent reports whether err, returned by a send, means that the
+// message would fail the same way if it were sent again.  A message
+// that was not answered may not have arrived, so it is sent again.
+func permanent(err error) bool {
+	var tooLong *api.MessageTooLong
+	var rejected *api.MessageRejected
+	var unsupported *impl.UnsupportedError
+	switch {
+	case errors.Is(err, impl.ErrReplyTimeout):
+		return false
+	case errors.As(err, &tooLong), errors.Is(err, impl.ErrUnknownRecipient), errors.As(err, &unsupported):
+		return true
+	case errors.As(err, &rejected):
+		return !rejected.Temporary
+	}
+	return false
+}
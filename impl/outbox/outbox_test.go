@@ -0,0 +1,223 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/impltest"
+)
+
+var testOptions = Options{RetryInterval: 10 * time.Millisecond, MaxRetryInterval: 50 * time.Millisecond, NoSync: true}
+
+// newService creates service id on dir.
+func newService(t *testing.T, dir directory.Directory, id string) impl.Service {
+	t.Helper()
+	return impltest.Start(t, func(id string) (impl.Service, error) {
+		return impl.NewMessageServiceWithOptions(id, impl.Options{Directory: dir})
+	}, id)[0]
+}
+
+// expect receives the messages with the given data from ms, in order.
+func expect(t *testing.T, ms impl.Service, data ...string) {
+	t.Helper()
+	for _, want := range data {
+		select {
+		case msg := <-ms.Receiver():
+			if string(msg.Data) != want {
+				t.Errorf("Received %q, expected %q", msg.Data, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Did not receive %q", want)
+		}
+	}
+}
+
+// TestResendAfterRestart queues messages for a recipient that is
+// down, restarts the sender, and ensures that they are delivered in
+// order once the recipient comes up.
+func TestResendAfterRestart(t *testing.T) {
+	dir := impltest.Directory(t, "alice", "bob")
+	path := filepath.Join(t.TempDir(), "outbox.log")
+
+	o, err := Open(newService(t, dir, "alice"), path, testOptions)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, data := range []string{"one", "two"} {
+		if err := o.Send("bob", []byte(data)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := o.Pending(); n != 2 {
+		t.Errorf("Pending = %d, expected 2", n)
+	}
+	o.Close()
+
+	// A torn record at the end of the log, as from a crash in
+	// the middle of a write, is ignored.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	o, err = Open(newService(t, dir, "alice"), path, testOptions)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer o.Close()
+	if err := o.Send("bob", []byte("three")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	bob := newService(t, dir, "bob")
+	defer bob.Close()
+	expect(t, bob, "one", "two", "three")
+
+	deadline := time.Now().Add(time.Second)
+	for o.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := o.Pending(); n != 0 {
+		t.Errorf("Pending = %d after delivery", n)
+	}
+}
+
+// TestCompaction ensures that delivered messages are removed from
+// the log.
+func TestCompaction(t *testing.T) {
+	dir := impltest.Directory(t, "alice", "bob")
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	bob := newService(t, dir, "bob")
+	defer bob.Close()
+
+	opts := testOptions
+	opts.CompactThreshold = 2
+	o, err := Open(newService(t, dir, "alice"), path, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, data := range []string{"one", "two"} {
+		o.Send("bob", []byte(data))
+	}
+	expect(t, bob, "one", "two")
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if fi, err := os.Stat(path); err == nil && fi.Size() == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if fi.Size() != 0 {
+		t.Errorf("Log was not compacted: %d bytes", fi.Size())
+	}
+	o.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if pending, _, err := replay(f); err != nil || len(pending) != 0 {
+		t.Errorf("replay = %v, %v", pending, err)
+	}
+}
+
+// TestPermanentFailure ensures that a message too long to send is
+// refused by Send, and that messages to unknown recipients or
+// refused for good are given up on without holding up the rest.
+func TestPermanentFailure(t *testing.T) {
+	dir := impltest.Directory(t, "alice", "bob")
+	bobAddr, _ := dir.Lookup("bob")
+	// Messages for carol reach bob, which refuses them.
+	dir.Set("carol", bobAddr)
+	bob, err := impl.NewMessageServiceWithOptions("bob", impl.Options{Directory: dir, Misaddressed: impl.RejectMisaddressed})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	failed := make(chan error, 2)
+	opts := testOptions
+	opts.OnFailure = func(recipient string, data []byte, err error) {
+		failed <- fmt.Errorf("%s: %w", data, err)
+	}
+	o, err := Open(newService(t, dir, "alice"), filepath.Join(t.TempDir(), "outbox.log"), opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer o.Close()
+
+	// The data fits in a frame, but the message does not.
+	var tooLong *api.MessageTooLong
+	if err := o.Send("bob", make([]byte, api.MaxMessageLen-4)); !errors.As(err, &tooLong) {
+		t.Errorf("Send of long message returned %v", err)
+	}
+	for _, recipient := range []string{"dave", "carol", "bob"} {
+		if err := o.Send(recipient, []byte(recipient)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	expect(t, bob, "bob")
+
+	var rejected *api.MessageRejected
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-failed:
+			switch {
+			case strings.HasPrefix(err.Error(), "dave:") && errors.Is(err, impl.ErrUnknownRecipient):
+			case strings.HasPrefix(err.Error(), "carol:") && errors.As(err, &rejected) && !rejected.Temporary:
+			default:
+				t.Errorf("Unexpected failure %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Failure was not reported")
+		}
+	}
+	if n := o.Pending(); n != 0 {
+		t.Errorf("Pending = %d after failures", n)
+	}
+}
+
+// TestOpenFailure ensures that Open closes the service it was given
+// when it cannot open the log.
+func TestOpenFailure(t *testing.T) {
+	ms := newService(t, impltest.Directory(t, "alice", "bob"), "alice")
+	if _, err := Open(ms, filepath.Join(t.TempDir(), "missing", "outbox.log"), testOptions); err == nil {
+		t.Fatal("Open of a log in a missing directory succeeded")
+	}
+	if _, ok := <-ms.Receiver(); ok {
+		t.Error("Service was not closed")
+	}
+}
+
+// TestPermanent checks which send errors are given up on.
+func TestPermanent(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("bob: %w", impl.ErrUnknownRecipient), true},
+		{&api.MessageTooLong{}, true},
+		{&api.MessageRejected{Msg: "misaddressed"}, true},
+		{&api.MessageRejected{Msg: "queue full", Temporary: true}, false},
+		{&impl.UnsupportedError{Feature: impl.FeatureRouting}, true},
+		{&impl.UnsupportedError{Feature: impl.FeatureCompression, Err: errors.New("corrupt gzip data")}, true},
+		{impl.ErrReplyTimeout, false},
+		{&impl.ThrottledError{Peer: "bob"}, false},
+		{errors.New("failed to connect"), false},
+	} {
+		if got := permanent(tc.err); got != tc.want {
+			t.Errorf("permanent(%v) = %v, expected %v", tc.err, got, tc.want)
+		}
+	}
+}
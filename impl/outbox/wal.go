@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// The log is a sequence of records, each of which is
+//
+//	length  uint32  length of the type, seq, and body
+//	type    byte    recMessage or recDelivered
+//	seq     uint64  sequence number of the message
+//	body    []byte  for recMessage, the recipient and data
+//	crc     uint32  CRC-32 (IEEE) of type, seq, and body
+//
+// with all integers big-endian.  A recMessage body is a two-byte
+// recipient length, the recipient, and the data.  A recDelivered
+// record marks the message with the same sequence number as
+// delivered, or as given up on.  A torn or corrupt record ends the
+// log; it and anything after it are discarded when the log is opened.
+
+const (
+	recMessage   byte = 1
+	recDelivered byte = 2
+)
+
+// maxRecord bounds the length field, so that a corrupt length cannot
+// cause a huge allocation.
+const maxRecord = 1 << 20
+
+// entry is a message in the outbox.
+type entry struct {
+	seq       uint64
+	recipient string
+	data      []byte
+}
+
+// appendRecord encodes a record onto b.
+func appendRecord(b []byte, typ byte, seq uint64, body []byte) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint32(b, uint32(1+8+len(body)))
+	b = append(b, typ)
+	b = binary.BigEndian.AppendUint64(b, seq)
+	b = append(b, body...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start+4:]))
+}
+
+// appendEntry encodes a recMessage record for e onto b.
+func appendEntry(b []byte, e *entry) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(e.recipient)))
+	body = append(body, e.recipient...)
+	body = append(body, e.data...)
+	return appendRecord(b, recMessage, e.seq, body)
+}
+
+// errCorrupt is returned by readRecord for a torn or corrupt record.
+var errCorrupt = errors.New("corrupt record")
+
+// readRecord reads the next record from r.  It returns io.EOF at a
+// clean end of the log.
+func readRecord(r *bufio.Reader) (typ byte, seq uint64, body []byte, err error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errCorrupt
+		}
+		return 0, 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 9 || n > maxRecord {
+		return 0, 0, nil, errCorrupt
+	}
+	rec := make([]byte, n+4)
+	if _, err := io.ReadFull(r, rec); err != nil {
+		return 0, 0, nil, errCorrupt
+	}
+	if crc32.ChecksumIEEE(rec[:n]) != binary.BigEndian.Uint32(rec[n:]) {
+		return 0, 0, nil, errCorrupt
+	}
+	return rec[0], binary.BigEndian.Uint64(rec[1:9]), rec[9:n], nil
+}
+
+// replay reads the log in f and returns the messages that have not
+// been delivered, in sequence order, and the highest sequence number
+// seen.
+func replay(f *os.File) (pending []*entry, maxSeq uint64, err error) {
+	r := bufio.NewReader(f)
+	var all []*entry
+	delivered := make(map[uint64]bool)
+	for {
+		typ, seq, body, err := readRecord(r)
+		if err == io.EOF || err == errCorrupt {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch typ {
+		case recMessage:
+			if len(body) < 2 || len(body) < 2+int(binary.BigEndian.Uint16(body)) {
+				return nil, 0, fmt.Errorf("%s: bad message record %d", f.Name(), seq)
+			}
+			n := 2 + int(binary.BigEndian.Uint16(body))
+			all = append(all, &entry{seq, string(body[2:n]), body[n:]})
+		case recDelivered:
+			delivered[seq] = true
+		default:
+			return nil, 0, fmt.Errorf("%s: unknown record type %d", f.Name(), typ)
+		}
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+	}
+	for _, e := range all {
+		if !delivered[e.seq] {
+			pending = append(pending, e)
+		}
+	}
+	return pending, maxSeq, nil
+}
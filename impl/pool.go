@@ -0,0 +1,291 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"cse586.messageservice/api"
+)
+
+// Connections to receivers that negotiated FeaturePersistent are kept
+// open after a message is sent, in a pool for each recipient, and are
+// reused for later messages.  The receiver answers each message on
+// such a connection with an ACK, ERROR or THROTTLED frame, so the
+// sender learns the outcome without the connection being closed.  At
+// most Options.MaxSendsPerPeer idle connections are kept for each
+// recipient, and each is closed once it has been idle for
+// Options.PoolIdleTimeout, or once it would exceed the number of
+// frames that the receiver's HANDSHAKE frame says it reads from a
+// connection.
+//
+// While a connection is idle, a goroutine watches it, so that one the
+// receiver closes is dropped from the pool.  A connection is taken
+// from the pool only once its watcher has stopped without seeing it
+// closed, and a message is never sent again once it has been written,
+// since the receiver may have delivered it.
+//
+// Flow control credit is granted per connection.  The receiver grants
+// it in its HANDSHAKE frame and renews it in every ACK, and the
+// sender spends one unit on each message.  A sender with no credit
+// left asks for more with a CREDIT frame, and holds the message back
+// if none is granted.
+
+// DefaultPoolIdleTimeout is the pool idle timeout used when
+// Options.PoolIdleTimeout is zero.
+const DefaultPoolIdleTimeout = 10 * time.Second
+
+// pooledConn is an outgoing stream connection and what is known
+// about the receiver at its other end.
+type pooledConn struct {
+	conn     net.Conn
+	addr     string
+	fr       *frameReader
+	features Feature
+	// credit is the number of messages that may still be sent on
+	// conn before the receiver grants more.
+	credit uint32
+	// frames counts the frames written to conn, and maxFrames is
+	// the receiver's limit on them, or zero if it has none.
+	frames    int
+	maxFrames int
+	// broken is set once conn can no longer be reused.
+	broken bool
+	// idle closes conn once it has been in the pool too long, and
+	// watched receives the result of the watcher's read.
+	idle    *time.Timer
+	watched chan error
+}
+
+// newConn returns a pooledConn for a new connection to addr, which
+// has been tracked.
+func newConn(conn net.Conn, addr string) *pooledConn {
+	return &pooledConn{conn: conn, addr: addr, fr: newFrameReader(conn)}
+}
+
+// takeConn removes an idle connection to recipient from the pool and
+// returns it, or returns nil if there is none that is still open.
+func (ms *messageService) takeConn(recipient string) *pooledConn {
+	for {
+		ms.mu.Lock()
+		idle := ms.pool[recipient]
+		if len(idle) == 0 {
+			ms.mu.Unlock()
+			return nil
+		}
+		pc := idle[len(idle)-1]
+		ms.setPool(recipient, idle[:len(idle)-1])
+		ms.mu.Unlock()
+
+		pc.idle.Stop()
+		// Stop the watcher.  Unless it timed out, the
+		// receiver closed the connection or wrote to it
+		// unasked.
+		pc.conn.SetReadDeadline(time.Unix(1, 0))
+		err := <-pc.watched
+		pc.conn.SetReadDeadline(time.Time{})
+		if isTimeout(err) {
+			return pc
+		}
+		ms.release(pc)
+	}
+}
+
+// putConn returns pc, which is connected to recipient, to the pool,
+// or closes it if it cannot be reused.
+func (ms *messageService) putConn(recipient string, pc *pooledConn) {
+	ms.mu.Lock()
+	if ms.closed || pc.broken || pc.features&FeaturePersistent == 0 ||
+		ms.opts.PoolIdleTimeout < 0 || pc.maxFrames > 0 && pc.frames+2 > pc.maxFrames ||
+		len(ms.pool[recipient]) >= ms.maxSends() {
+		ms.mu.Unlock()
+		ms.release(pc)
+		return
+	}
+	pc.conn.SetReadDeadline(time.Time{})
+	pc.idle = time.AfterFunc(ms.opts.PoolIdleTimeout, func() { ms.expire(recipient, pc) })
+	pc.watched = make(chan error, 1)
+	ms.pool[recipient] = append(ms.pool[recipient], pc)
+	ms.mu.Unlock()
+
+	go func() {
+		_, err := pc.fr.r.Peek(1)
+		pc.watched <- err
+		if !isTimeout(err) {
+			ms.expire(recipient, pc)
+		}
+	}()
+}
+
+// expire closes pc if it is still idle in the pool for recipient.
+func (ms *messageService) expire(recipient string, pc *pooledConn) {
+	ms.mu.Lock()
+	idle := ms.pool[recipient]
+	for i := range idle {
+		if idle[i] == pc {
+			ms.setPool(recipient, append(idle[:i:i], idle[i+1:]...))
+			ms.mu.Unlock()
+			pc.idle.Stop()
+			ms.release(pc)
+			return
+		}
+	}
+	ms.mu.Unlock()
+}
+
+// setPool sets the idle connections to recipient.  ms.mu must be
+// held.
+func (ms *messageService) setPool(recipient string, idle []*pooledConn) {
+	if len(idle) == 0 {
+		delete(ms.pool, recipient)
+	} else {
+		ms.pool[recipient] = idle
+	}
+}
+
+// closePool closes every idle connection.  It is called by Close.
+func (ms *messageService) closePool() {
+	ms.mu.Lock()
+	var idle []*pooledConn
+	for recipient, conns := range ms.pool {
+		idle = append(idle, conns...)
+		delete(ms.pool, recipient)
+	}
+	ms.mu.Unlock()
+	for _, pc := range idle {
+		pc.idle.Stop()
+		ms.release(pc)
+	}
+}
+
+// release closes pc and forgets it.
+func (ms *messageService) release(pc *pooledConn) {
+	pc.conn.Close()
+	ms.untrack(pc.conn)
+}
+
+// write writes a frame to pc.
+func (pc *pooledConn) write(frame []byte) error {
+	pc.frames++
+	_, err := pc.conn.Write(frame)
+	return err
+}
+
+// sendPooled sends msg to recipient over pc, which is to a receiver
+// that supports FeaturePersistent, and waits for its answer.  pc is
+// returned to the pool unless it can no longer be used.  announce is
+// set if msg is the first frame on a new connection.
+func (ms *messageService) sendPooled(recipient string, pc *pooledConn, msg *api.Message, announce bool) error {
+	if ms.opts.FlowControl && pc.features&FeatureCredit != 0 && pc.credit == 0 {
+		if err := ms.requestCredit(pc); err != nil {
+			ms.release(pc)
+			return err
+		}
+		if pc.credit == 0 {
+			ms.putConn(recipient, pc)
+			return &ThrottledError{recipient, "recipient granted no credit", 0}
+		}
+	}
+	datas, err := ms.encode(msg, pc.features, announce)
+	if err != nil {
+		ms.putConn(recipient, pc)
+		return err
+	}
+	if err := pc.write(datas); err != nil {
+		ms.release(pc)
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	if pc.credit > 0 {
+		pc.credit--
+	}
+
+	reply, err := ms.readPooled(pc, api.Kind_ACK)
+	if err != nil {
+		ms.release(pc)
+		return err
+	}
+	if reply != nil && reply.Kind == api.Kind_ACK {
+		pc.credit = reply.Credit
+	}
+	ms.putConn(recipient, pc)
+	return refusal(recipient, reply)
+}
+
+// requestCredit asks the receiver at the other end of pc for credit.
+func (ms *messageService) requestCredit(pc *pooledConn) error {
+	frame, err := marshalFrame(&api.Message{Sender: ms.id, Kind: api.Kind_CREDIT})
+	if err != nil {
+		return err
+	}
+	if err := pc.write(frame); err != nil {
+		return fmt.Errorf("failed to request credit: %w", err)
+	}
+	reply, err := ms.readPooled(pc, api.Kind_CREDIT)
+	if err != nil {
+		return err
+	}
+	if reply == nil || reply.Kind != api.Kind_CREDIT {
+		return errors.New("no answer to credit request")
+	}
+	pc.credit = reply.Credit
+	return nil
+}
+
+// readPooled waits, for at most the reply timeout, for the receiver
+// at the other end of pc to answer with a frame of kind want or a
+// refusal.  It returns nil rather than an answer if the receiver
+// turns out not to support FeaturePersistent, and so accepts the
+// message by closing the connection, and ErrReplyTimeout if it does
+// not answer in time.
+func (ms *messageService) readPooled(pc *pooledConn, want api.Kind) (*api.Message, error) {
+	pc.conn.SetReadDeadline(time.Now().Add(ms.opts.ReplyTimeout))
+	for {
+		reply, err := pc.fr.readFrame()
+		switch {
+		case isTimeout(err):
+			return nil, ErrReplyTimeout
+		case err == io.EOF && pc.features&FeaturePersistent == 0:
+			return nil, nil
+		case err == io.EOF:
+			return nil, errors.New("recipient closed the connection without answering")
+		case err != nil:
+			return nil, err
+		}
+		switch reply.Kind {
+		case api.Kind_HANDSHAKE:
+			ms.negotiate(pc, reply)
+			if pc.features&FeaturePersistent == 0 {
+				// The receiver answers only refusals, so
+				// the connection must be closed for it to
+				// finish.
+				closeWrite(pc.conn)
+			}
+		case want, api.Kind_ERROR, api.Kind_THROTTLED:
+			return reply, nil
+		}
+	}
+}
+
+// negotiate records what the receiver's HANDSHAKE frame says about
+// it.
+func (ms *messageService) negotiate(pc *pooledConn, reply *api.Message) {
+	pc.features, pc.credit = Feature(reply.Features), reply.Credit
+	pc.maxFrames = int(reply.MaxFrames)
+	ms.setNegotiated(pc.addr, pc.features)
+}
+
+// isTimeout reports whether err is a timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// closeWrite shuts down the writing side of conn, if it has one, so
+// that the receiver sees the end of the stream.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
@@ -0,0 +1,112 @@
+package impl
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"cse586.messageservice/impl/impltest"
+)
+
+// newPoolPair creates services for alice and bob with the given
+// options.
+func newPoolPair(t *testing.T, aliceOpts, bobOpts Options) (*messageService, Service) {
+	t.Helper()
+	dir := impltest.Directory(t, "alice", "bob")
+	alice := impltest.Start(t, start(dir, aliceOpts), "alice")[0]
+	bob := impltest.Start(t, start(dir, bobOpts), "bob")[0]
+	return alice.(*messageService), bob
+}
+
+// pooled returns the idle connections from ms to recipient.
+func pooled(ms *messageService, recipient string) []*pooledConn {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return append([]*pooledConn(nil), ms.pool[recipient]...)
+}
+
+// TestPooledConnections ensures that messages to a recipient reuse
+// one connection.
+func TestPooledConnections(t *testing.T) {
+	alice, bob := newPoolPair(t, Options{}, Options{})
+	for i := 0; i < 5; i++ {
+		if err := alice.Send("bob", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if rmsg := <-bob.Receiver(); string(rmsg.Data) != fmt.Sprint(i) {
+			t.Errorf("Received %q, expected %d", rmsg.Data, i)
+		}
+	}
+	// The first message learns that bob supports persistent
+	// connections, and the rest share the next connection.
+	if idle := pooled(alice, "bob"); len(idle) != 1 || idle[0].frames != 4 {
+		t.Errorf("Pool holds %d connections", len(idle))
+	}
+}
+
+// TestClosedConnection ensures that a pooled connection closed by
+// the recipient leaves the pool, and the next message uses a new one.
+func TestClosedConnection(t *testing.T) {
+	alice, bob := newPoolPair(t, Options{}, Options{IdleTimeout: 50 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		if err := alice.Send("bob", []byte("hello")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		<-bob.Receiver()
+	}
+	if idle := pooled(alice, "bob"); len(idle) != 1 {
+		t.Fatalf("Pool holds %d connections", len(idle))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(pooled(alice, "bob")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Closed connection was kept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := alice.Send("bob", []byte("again")); err != nil {
+		t.Fatalf("Send after connection closed failed: %v", err)
+	}
+	if rmsg := <-bob.Receiver(); string(rmsg.Data) != "again" {
+		t.Errorf("Received %q", rmsg.Data)
+	}
+}
+
+// TestAdvertisedFrameLimit ensures that a pooled connection is
+// retired before it reaches the recipient's frame limit.
+func TestAdvertisedFrameLimit(t *testing.T) {
+	alice, bob := newPoolPair(t, Options{}, Options{MaxFramesPerConnection: 3})
+	for i := 0; i < 8; i++ {
+		if err := alice.Send("bob", []byte("hello")); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+		<-bob.Receiver()
+	}
+	if n := bob.Stats().FrameLimited; n != 0 {
+		t.Errorf("FrameLimited = %d", n)
+	}
+	for _, pc := range pooled(alice, "bob") {
+		if pc.frames > 3 {
+			t.Errorf("Pooled connection has carried %d frames", pc.frames)
+		}
+	}
+}
+
+// TestPoolIdleTimeout ensures that idle connections are closed.
+func TestPoolIdleTimeout(t *testing.T) {
+	alice, bob := newPoolPair(t, Options{PoolIdleTimeout: 20 * time.Millisecond}, Options{})
+	for i := 0; i < 2; i++ {
+		if err := alice.Send("bob", []byte("hello")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		<-bob.Receiver()
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(pooled(alice, "bob")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Idle connection was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,216 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/envelope"
+	"cse586.messageservice/impl/rpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultQueueLen is the per-subscriber queue length used when
+// BrokerOptions.QueueLen is zero.
+const DefaultQueueLen = 256
+
+// DefaultMaxFailures is the number of consecutive failed sends after
+// which a subscriber is dropped when BrokerOptions.MaxFailures is
+// zero.
+const DefaultMaxFailures = 5
+
+// BrokerOptions configures a Broker.  The zero value is valid.
+type BrokerOptions struct {
+	// QueueLen is the number of publications that may wait to be
+	// sent to each subscriber.  Publications that arrive while a
+	// subscriber's queue is full are dropped for that subscriber.
+	// Zero selects DefaultQueueLen.
+	QueueLen int
+	// MaxFailures is the number of publications in a row that may
+	// fail to reach a subscriber before it is dropped, along with
+	// its subscriptions.  A subscriber that the service reports
+	// as unknown, or that rejects a publication permanently, is
+	// dropped at once.  Zero selects DefaultMaxFailures.
+	MaxFailures int
+}
+
+// Broker accepts subscriptions and publications from Clients and
+// sends each publication to every subscriber with a matching
+// pattern.  Each subscriber has its own queue, so that a slow or
+// unreachable subscriber does not delay the others.
+type Broker struct {
+	e           *rpc.Endpoint
+	queueLen    int
+	maxFailures int
+	dropped     atomic.Uint64
+	wg          sync.WaitGroup
+
+	mu     sync.Mutex
+	subs   map[string]*subscriber
+	closed bool
+}
+
+// subscriber holds one subscriber's patterns and queue.
+type subscriber struct {
+	patterns map[string]struct{}
+	queue    chan *api.Publication
+}
+
+// NewBroker creates a Broker on ms.  The Broker owns ms from then on,
+// and closes it when it is closed.  Plain messages sent to ms are
+// discarded.
+func NewBroker(ms impl.Service, opts BrokerOptions) *Broker {
+	if opts.QueueLen <= 0 {
+		opts.QueueLen = DefaultQueueLen
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = DefaultMaxFailures
+	}
+	b := &Broker{
+		e:           rpc.New(ms),
+		queueLen:    opts.QueueLen,
+		maxFailures: opts.MaxFailures,
+		subs:        make(map[string]*subscriber),
+	}
+	b.e.Handle(methodSubscribe, b.subscribe)
+	b.e.Handle(methodUnsubscribe, b.unsubscribe)
+	b.e.Handle(methodPublish, b.publish)
+	go func() {
+		for range b.e.Receiver() {
+		}
+	}()
+	return b
+}
+
+// Dropped returns the number of publications dropped because a
+// subscriber's queue was full, or because they could not be sent to
+// it.
+func (b *Broker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Close stops the Broker and closes its service.  Publications still
+// queued are discarded.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	for id, sub := range b.subs {
+		close(sub.queue)
+		delete(b.subs, id)
+	}
+	b.mu.Unlock()
+	err := b.e.Close()
+	b.wg.Wait()
+	return err
+}
+
+func (b *Broker) subscribe(ctx context.Context, sender string, payload []byte) ([]byte, error) {
+	pattern := string(payload)
+	if err := checkPattern(pattern); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, rpc.ErrClosed
+	}
+	sub, ok := b.subs[sender]
+	if !ok {
+		sub = &subscriber{
+			patterns: make(map[string]struct{}),
+			queue:    make(chan *api.Publication, b.queueLen),
+		}
+		b.subs[sender] = sub
+		b.wg.Add(1)
+		go b.forward(sender, sub.queue)
+	}
+	sub.patterns[pattern] = struct{}{}
+	return nil, nil
+}
+
+func (b *Broker) unsubscribe(ctx context.Context, sender string, payload []byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub, ok := b.subs[sender]
+	if !ok {
+		return nil, nil
+	}
+	delete(sub.patterns, string(payload))
+	if len(sub.patterns) == 0 {
+		close(sub.queue)
+		delete(b.subs, sender)
+	}
+	return nil, nil
+}
+
+func (b *Broker) publish(ctx context.Context, sender string, payload []byte) ([]byte, error) {
+	pub := &api.Publication{}
+	if err := proto.Unmarshal(payload, pub); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(pub.Topic); err != nil {
+		return nil, err
+	}
+	pub.Publisher = sender
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subs {
+		for pattern := range sub.patterns {
+			if !match(pattern, pub.Topic) {
+				continue
+			}
+			select {
+			case sub.queue <- pub:
+			default:
+				b.dropped.Add(1)
+			}
+			break
+		}
+	}
+	return nil, nil
+}
+
+// forward sends the publications in queue to subscriber id until
+// the queue is closed.  Publications that cannot be sent are lost,
+// and a subscriber that cannot be reached is dropped.
+func (b *Broker) forward(id string, queue chan *api.Publication) {
+	defer b.wg.Done()
+	failures := 0
+	for pub := range queue {
+		err := envelope.Send(b.e, id, envelope.Publication, pub)
+		if err == nil {
+			failures = 0
+			continue
+		}
+		b.dropped.Add(1)
+		if failures++; failures >= b.maxFailures || gone(err) {
+			b.drop(id, queue)
+		}
+	}
+}
+
+// drop removes subscriber id, whose queue is queue, unless it has
+// already been removed.  Publications left in the queue are dropped.
+func (b *Broker) drop(id string, queue chan *api.Publication) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sub, ok := b.subs[id]; !ok || sub.queue != queue {
+		return
+	}
+	delete(b.subs, id)
+	close(queue)
+	b.dropped.Add(uint64(len(queue)))
+	for range queue {
+	}
+}
+
+// gone reports whether err, returned by sending to a subscriber,
+// means that the subscriber will never accept a publication.
+func gone(err error) bool {
+	var rejected *api.MessageRejected
+	return errors.Is(err, impl.ErrUnknownRecipient) || errors.As(err, &rejected) && !rejected.Temporary
+}
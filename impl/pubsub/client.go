@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/envelope"
+	"cse586.messageservice/impl/rpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Client publishes and subscribes through one or more brokers.
+// Subscriptions are made with every broker, and each publication is
+// sent to the first broker that accepts it, so that any one broker
+// is enough to keep messages flowing and each publication is
+// delivered once.
+//
+// Publications and plain messages wait for the application in queues
+// as long as the service's.  Those that arrive while their queue is
+// full are dropped and counted by Dropped, so that a slow subscriber
+// never delays the replies of its brokers.
+type Client struct {
+	e            *rpc.Endpoint
+	brokers      []string
+	publications chan *api.Publication
+	receiver     chan *api.Message
+	dropped      atomic.Uint64
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewClient creates a Client on ms that uses the brokers with the
+// given IDs.  The Client owns ms from then on, and closes it when it
+// is closed.
+func NewClient(ms impl.Service, brokers ...string) *Client {
+	queueLen := cap(ms.Receiver())
+	if queueLen == 0 {
+		queueLen = 1
+	}
+	c := &Client{
+		e:            rpc.New(ms),
+		brokers:      brokers,
+		publications: make(chan *api.Publication, queueLen),
+		receiver:     make(chan *api.Message, queueLen),
+	}
+	go c.dispatch()
+	return c
+}
+
+// Subscribe asks every broker to send this Client the publications
+// whose topics match pattern.  It succeeds if any broker accepts the
+// subscription.
+func (c *Client) Subscribe(ctx context.Context, pattern string) error {
+	if err := checkPattern(pattern); err != nil {
+		return err
+	}
+	return c.all(ctx, methodSubscribe, pattern)
+}
+
+// Unsubscribe cancels a subscription made with Subscribe.
+func (c *Client) Unsubscribe(ctx context.Context, pattern string) error {
+	return c.all(ctx, methodUnsubscribe, pattern)
+}
+
+// all calls method on every broker, returning nil if any call
+// succeeds and the first error otherwise.
+func (c *Client) all(ctx context.Context, method, pattern string) error {
+	var firstErr error
+	ok := false
+	for _, broker := range c.brokers {
+		if _, err := c.e.Call(ctx, broker, method, []byte(pattern)); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("broker %s: %w", broker, err)
+			}
+		} else {
+			ok = true
+		}
+	}
+	if ok {
+		return nil
+	}
+	if firstErr == nil {
+		firstErr = errors.New("no brokers")
+	}
+	return firstErr
+}
+
+// Publish publishes payload on topic through the first broker that
+// accepts it.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte) error {
+	if err := checkTopic(topic); err != nil {
+		return err
+	}
+	body, err := proto.Marshal(&api.Publication{Topic: topic, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %v", err)
+	}
+	err = errors.New("no brokers")
+	for _, broker := range c.brokers {
+		if _, err = c.e.Call(ctx, broker, methodPublish, body); err == nil {
+			return nil
+		}
+		err = fmt.Errorf("broker %s: %w", broker, err)
+	}
+	return err
+}
+
+// Publications returns a channel that receives the publications
+// sent to this Client by its brokers.
+func (c *Client) Publications() <-chan *api.Publication {
+	return c.publications
+}
+
+// Receiver returns a channel that receives plain messages sent to
+// this Client's service.
+func (c *Client) Receiver() <-chan *api.Message {
+	return c.receiver
+}
+
+// Dropped returns the number of publications and plain messages
+// dropped because the application did not read them in time.
+func (c *Client) Dropped() uint64 {
+	return c.dropped.Load() + c.e.Dropped()
+}
+
+// Close closes the Client and its service.  Subscriptions are not
+// cancelled; brokers discard publications they cannot deliver.
+// Calling Close more than once returns the result of the first call.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.e.Close()
+	})
+	return c.closeErr
+}
+
+// dispatch separates publications from plain messages until the
+// Endpoint's Receiver channel is closed.
+func (c *Client) dispatch() {
+	defer close(c.publications)
+	defer close(c.receiver)
+	for msg := range c.e.Receiver() {
+		pub := &api.Publication{}
+		if envelope.Open(msg, envelope.Publication, pub) {
+			select {
+			case c.publications <- pub:
+			default:
+				c.dropped.Add(1)
+			}
+		} else {
+			select {
+			case c.receiver <- msg:
+			default:
+				c.dropped.Add(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Package pubsub provides topic-based publish/subscribe messaging
+// on top of an impl.Service.  Brokers are ordinary services,
+// identified by their IDs in the directory, that accept subscriptions
+// and publications as rpc calls and send each publication to the
+// subscribers whose patterns match its topic, in an envelope (see
+// package envelope).
+package pubsub
+
+// The rpc methods implemented by a Broker.
+const (
+	methodSubscribe   = "pubsub.subscribe"
+	methodUnsubscribe = "pubsub.unsubscribe"
+	methodPublish     = "pubsub.publish"
+)
@@ -0,0 +1,194 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestMatch checks wildcard matching of topics.
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a/b/c", false},
+		{"*/b", "a/b", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "x/y", true},
+		{"a/b/c", "a/b", false},
+	} {
+		if got := match(tc.pattern, tc.topic); got != tc.want {
+			t.Errorf("match(%q, %q) = %v", tc.pattern, tc.topic, got)
+		}
+	}
+	for _, bad := range []string{"", "a/#/b", "a*", "a/b#"} {
+		if checkPattern(bad) == nil {
+			t.Errorf("Pattern %q was accepted", bad)
+		}
+	}
+}
+
+// newServices creates services for ids on loopback addresses.
+func newServices(t *testing.T, ids ...string) []impl.Service {
+	t.Helper()
+	opts := impl.Options{Directory: impltest.Directory(t, ids...)}
+	return impltest.Start(t, func(id string) (impl.Service, error) {
+		return impl.NewMessageServiceWithOptions(id, opts)
+	}, ids...)
+}
+
+// TestPublishSubscribe has two subscribers with different patterns
+// and checks which publications each receives, with two brokers of
+// which one is closed partway through.
+func TestPublishSubscribe(t *testing.T) {
+	ms := newServices(t, "b1", "b2", "pub", "s1", "s2")
+	b1 := NewBroker(ms[0], BrokerOptions{})
+	defer b1.Close()
+	b2 := NewBroker(ms[1], BrokerOptions{})
+	defer b2.Close()
+	pub := NewClient(ms[2], "b1", "b2")
+	defer pub.Close()
+	s1 := NewClient(ms[3], "b1", "b2")
+	defer s1.Close()
+	s2 := NewClient(ms[4], "b1", "b2")
+	defer s2.Close()
+
+	ctx := context.Background()
+	if err := s1.Subscribe(ctx, "lab/*/temp"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if err := s2.Subscribe(ctx, "lab/#"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	// s2's second pattern overlaps its first, but it should
+	// still receive each publication once.
+	if err := s2.Subscribe(ctx, "lab/2/*"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	expect := func(c *Client, topics ...string) {
+		t.Helper()
+		for _, topic := range topics {
+			select {
+			case p := <-c.Publications():
+				if p.Topic != topic || p.Publisher != "pub" {
+					t.Errorf("Received %v, expected topic %s", p, topic)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Did not receive %s", topic)
+			}
+		}
+		select {
+		case p := <-c.Publications():
+			t.Errorf("Unexpected publication %v", p)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	if err := pub.Publish(ctx, "lab/2/temp", []byte("21")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	expect(s1, "lab/2/temp")
+	expect(s2, "lab/2/temp")
+
+	b1.Close()
+	if err := pub.Publish(ctx, "lab/2/humidity", []byte("40")); err != nil {
+		t.Fatalf("Publish with one broker down failed: %v", err)
+	}
+	expect(s1)
+	expect(s2, "lab/2/humidity")
+}
+
+// TestClientCloseConcurrent closes a Client from several goroutines
+// at once.
+func TestClientCloseConcurrent(t *testing.T) {
+	ms := newServices(t, "client")
+	c := NewClient(ms[0], "broker")
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() { done <- c.Close() }()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Close returned %v", err)
+		}
+	}
+}
+
+// TestDeadSubscriber ensures that a broker drops a subscriber that
+// it can no longer reach, and counts the publications it lost.
+func TestDeadSubscriber(t *testing.T) {
+	ms := newServices(t, "broker", "pub", "sub")
+	b := NewBroker(ms[0], BrokerOptions{MaxFailures: 2})
+	defer b.Close()
+	pub := NewClient(ms[1], "broker")
+	defer pub.Close()
+	sub := NewClient(ms[2], "broker")
+
+	ctx := context.Background()
+	if err := sub.Subscribe(ctx, "lab/#"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	sub.Close()
+	for i := 0; i < 2; i++ {
+		if err := pub.Publish(ctx, "lab/1/temp", []byte("20")); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		_, ok := b.subs["sub"]
+		b.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Dead subscriber was not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := b.Dropped(); n != 2 {
+		t.Errorf("Dropped = %d, expected 2", n)
+	}
+}
+
+// TestClientDropped ensures that a Client counts the publications and
+// plain messages that arrive while the application is not reading
+// them.
+func TestClientDropped(t *testing.T) {
+	ms := newServices(t, "broker", "pub", "sub")
+	b := NewBroker(ms[0], BrokerOptions{})
+	defer b.Close()
+	pub := NewClient(ms[1], "broker")
+	defer pub.Close()
+	queueLen := cap(ms[2].Receiver())
+	sub := NewClient(ms[2], "broker")
+	defer sub.Close()
+
+	ctx := context.Background()
+	if err := sub.Subscribe(ctx, "#"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	for i := 0; i < queueLen+1; i++ {
+		if err := pub.Publish(ctx, "lab/1/temp", []byte("20")); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for sub.Dropped() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Unread publication was not counted as dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
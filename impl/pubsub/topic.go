@@ -0,0 +1,56 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topics are sequences of levels separated by '/', such as
+// "sensors/lab2/temp".  A subscription pattern is a topic in which a
+// level may be "*", which matches any single level, and the last
+// level may be "#", which matches any number of levels, including
+// none.  So "sensors/*/temp" matches "sensors/lab2/temp", and
+// "sensors/#" matches it as well as "sensors".
+
+// checkTopic returns an error if topic cannot be published to.
+func checkTopic(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("empty topic")
+	}
+	if strings.ContainsAny(topic, "*#") {
+		return fmt.Errorf("topic %q contains a wildcard", topic)
+	}
+	return nil
+}
+
+// checkPattern returns an error if pattern is not a valid
+// subscription pattern.
+func checkPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	levels := strings.Split(pattern, "/")
+	for i, level := range levels {
+		if level == "#" && i == len(levels)-1 || level == "*" {
+			continue
+		}
+		if strings.ContainsAny(level, "*#") {
+			return fmt.Errorf("pattern %q has a misplaced wildcard", pattern)
+		}
+	}
+	return nil
+}
+
+// match reports whether topic matches pattern.
+func match(pattern, topic string) bool {
+	ps, ts := strings.Split(pattern, "/"), strings.Split(topic, "/")
+	for i, p := range ps {
+		if p == "#" {
+			return true
+		}
+		if i >= len(ts) || p != "*" && p != ts[i] {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
@@ -0,0 +1,145 @@
+package impl
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"cse586.messageservice/api"
+)
+
+// Backpressure selects what a MessageService does with an incoming
+// message when its receive queue is full.
+type Backpressure int
+
+const (
+	// Block waits for the application to read from the queue.
+	// The connection stops being read in the meantime, so a
+	// stream sender gets no reply, and its Send returns
+	// ErrReplyTimeout once Options.ReplyTimeout has passed,
+	// although the message is delivered once there is room.  It
+	// is the default.
+	Block Backpressure = iota
+	// DropNewest discards the incoming message.
+	DropNewest
+	// DropOldest discards the message at the head of the queue
+	// to make room for the incoming one.
+	DropOldest
+	// Reject discards the incoming message and, on stream
+	// transports, sends an error frame back to the sender, whose
+	// Send then fails with api.MessageRejected.
+	Reject
+)
+
+// errQueueFull is the reason given when Reject refuses a message.
+var errQueueFull = errors.New("receive queue full")
+
+// Stats is a snapshot of the counters kept by a MessageService.
+type Stats struct {
+	// Received counts messages placed on the receive queue.
+	Received uint64
+	// Dropped counts messages discarded by DropNewest or
+	// DropOldest.
+	Dropped uint64
+	// Rejected counts messages refused by Reject.
+	Rejected uint64
+	// Misaddressed counts messages for other recipients that
+	// were dropped, rejected, or could not be forwarded.
+	Misaddressed uint64
+	// Forwarded counts messages forwarded to other recipients.
+	Forwarded uint64
+	// Throttled counts messages refused by the receive rate
+	// limit.
+	Throttled uint64
+	// RefusedConnections counts connections closed on arrival
+	// because of Options.MaxConnections.
+	RefusedConnections uint64
+	// TimedOut counts connections closed by the idle or read
+	// timeout.
+	TimedOut uint64
+	// FrameLimited counts connections closed by
+	// Options.MaxFramesPerConnection.
+	FrameLimited uint64
+}
+
+// counters holds the live values behind Stats.
+type counters struct {
+	received     atomic.Uint64
+	dropped      atomic.Uint64
+	rejected     atomic.Uint64
+	misaddressed atomic.Uint64
+	forwarded    atomic.Uint64
+	throttled    atomic.Uint64
+	refused      atomic.Uint64
+	timedOut     atomic.Uint64
+	frameLimited atomic.Uint64
+}
+
+// Stats returns a snapshot of the service's counters.
+func (ms *messageService) Stats() Stats {
+	return Stats{
+		Received:     ms.counters.received.Load(),
+		Dropped:      ms.counters.dropped.Load(),
+		Rejected:     ms.counters.rejected.Load(),
+		Misaddressed: ms.counters.misaddressed.Load(),
+		Forwarded:    ms.counters.forwarded.Load(),
+		Throttled:    ms.counters.throttled.Load(),
+
+		RefusedConnections: ms.counters.refused.Load(),
+		TimedOut:           ms.counters.timedOut.Load(),
+		FrameLimited:       ms.counters.frameLimited.Load(),
+	}
+}
+
+// deliver places msg on the receive queue according to the
+// configured Backpressure policy.  It returns errQueueFull if the
+// message was rejected, or errClosed if the service was closed while
+// it was blocked.  The caller must be registered with begin or track.
+func (ms *messageService) deliver(msg *api.Message) error {
+	switch ms.opts.Backpressure {
+	case DropNewest:
+		select {
+		case ms.receiver <- msg:
+		case <-ms.done:
+			return errClosed
+		default:
+			ms.counters.dropped.Add(1)
+			return nil
+		}
+	case DropOldest:
+		for queued := false; !queued; {
+			select {
+			case ms.receiver <- msg:
+				queued = true
+			case <-ms.done:
+				return errClosed
+			default:
+				// Make room, unless the application
+				// has emptied the queue since the
+				// send above was attempted.
+				select {
+				case <-ms.receiver:
+					ms.counters.dropped.Add(1)
+				default:
+				}
+			}
+		}
+	case Reject:
+		select {
+		case ms.receiver <- msg:
+		case <-ms.done:
+			return errClosed
+		default:
+			ms.counters.rejected.Add(1)
+			return errQueueFull
+		}
+	default:
+		select {
+		case ms.receiver <- msg:
+		case <-ms.done:
+			return errClosed
+		}
+	}
+	ms.counters.received.Add(1)
+	ms.metrics.receive(msg)
+	return nil
+}
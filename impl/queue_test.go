@@ -0,0 +1,86 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl/impltest"
+)
+
+// newQueuePair creates a sender and a recipient with a one-message
+// receive queue and the given policy.
+func newQueuePair(t *testing.T, policy Backpressure) (Service, Service) {
+	t.Helper()
+	dir := impltest.Directory(t, "alice", "bob")
+	alice := impltest.Start(t, start(dir, Options{ReplyTimeout: 100 * time.Millisecond}), "alice")[0]
+	bob := impltest.Start(t, start(dir, Options{ReceiveBuffer: 1, Backpressure: policy}), "bob")[0]
+	return alice, bob
+}
+
+// TestBackpressurePolicies fills a one-message queue with three
+// messages under each policy and checks what is kept and counted.
+func TestBackpressurePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   Backpressure
+		kept     string
+		stats    Stats
+		rejected int
+	}{
+		{"DropNewest", DropNewest, "0", Stats{Received: 1, Dropped: 2}, 0},
+		{"DropOldest", DropOldest, "2", Stats{Received: 3, Dropped: 2}, 0},
+		{"Reject", Reject, "0", Stats{Received: 1, Rejected: 2}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice, bob := newQueuePair(t, tc.policy)
+			rejected := 0
+			for i := 0; i < 3; i++ {
+				err := alice.Send("bob", []byte(fmt.Sprint(i)))
+				var rej *api.MessageRejected
+				if errors.As(err, &rej) {
+					rejected++
+					if !rej.Temporary {
+						t.Errorf("Refusal by full queue is not temporary: %v", err)
+					}
+				} else if err != nil {
+					t.Fatalf("Send failed: %v", err)
+				}
+			}
+			if rejected != tc.rejected {
+				t.Errorf("%d sends rejected, expected %d", rejected, tc.rejected)
+			}
+			if rmsg := <-bob.Receiver(); string(rmsg.Data) != tc.kept {
+				t.Errorf("Queue held %q, expected %q", rmsg.Data, tc.kept)
+			}
+			if stats := bob.Stats(); stats != tc.stats {
+				t.Errorf("Stats = %+v, expected %+v", stats, tc.stats)
+			}
+		})
+	}
+}
+
+// TestBackpressureBlock ensures that a blocked message is delivered
+// once the application reads the queue, and that the sender is not
+// held up for longer than the reply timeout, after which it is told
+// that the message was not answered.
+func TestBackpressureBlock(t *testing.T) {
+	alice, bob := newQueuePair(t, Block)
+	start := time.Now()
+	if err := alice.Send("bob", []byte("0")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := alice.Send("bob", []byte("1")); err != ErrReplyTimeout {
+		t.Errorf("Blocked send returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Blocked send took %v", elapsed)
+	}
+	for i := 0; i < 2; i++ {
+		if rmsg := <-bob.Receiver(); string(rmsg.Data) != fmt.Sprint(i) {
+			t.Errorf("Received %q, expected %d", rmsg.Data, i)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package impl
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Rate is a token-bucket rate limit: on average Limit messages per
+// second, with bursts of up to Burst messages.  The zero value means
+// no limit.
+type Rate struct {
+	Limit float64
+	// Burst is the bucket size.  Zero selects the smallest whole
+	// number of messages that is at least Limit, and at least 1.
+	Burst int
+}
+
+// ThrottledError is returned by Send when a message is held back by
+// a rate limit or by flow control.  It is also the reason given to a
+// sender whose message is refused by the receiver's rate limit.
+type ThrottledError struct {
+	Peer   string // Peer is the recipient, or the sender's host, that is limited
+	Reason string
+	// RetryAfter is how long until the message would be allowed,
+	// or zero if unknown.
+	RetryAfter time.Duration
+}
+
+func (err *ThrottledError) Error() string {
+	if err.RetryAfter > 0 {
+		return fmt.Sprintf("%s throttled: %s; retry after %v", err.Peer, err.Reason, err.RetryAfter)
+	}
+	return fmt.Sprintf("%s throttled: %s", err.Peer, err.Reason)
+}
+
+// maxBuckets is the number of peers a limiter tracks before it
+// forgets those whose buckets are full.
+const maxBuckets = 1024
+
+// limiter applies a Rate to each of a set of peers separately.
+type limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket is the state of one peer's token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a limiter for r, or nil if r is no limit.
+func newLimiter(r Rate) *limiter {
+	if r.Limit <= 0 {
+		return nil
+	}
+	burst := float64(r.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(r.Limit))
+	}
+	return &limiter{
+		rate:    r.Limit,
+		burst:   burst,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from peer's bucket.  If there is none, it
+// returns how long until there will be one.  A nil limiter allows
+// everything.
+func (l *limiter) allow(peer string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[peer]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[peer] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets the peers whose buckets have refilled, since a new
+// bucket behaves the same.
+func (l *limiter) prune(now time.Time) {
+	for peer, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, peer)
+		}
+	}
+}
+
+// remoteHost returns the key under which the receive rate limit
+// applies to a message from remote.  It is the host, not the sender
+// ID, which the sender chooses freely, nor the port, which changes
+// with each connection.  Every peer on a Unix socket shares one
+// key.
+func remoteHost(remote net.Addr) string {
+	switch a := remote.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	case nil:
+		return ""
+	}
+	return remote.String()
+}
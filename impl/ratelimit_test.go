@@ -0,0 +1,105 @@
+package impl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestLimiter checks token-bucket refill and per-peer buckets.
+func TestLimiter(t *testing.T) {
+	l := newLimiter(Rate{Limit: 10, Burst: 2})
+	clock := time.Unix(1000, 0)
+	l.now = func() time.Time { return clock }
+
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.allow("bob"); ok != want {
+			t.Errorf("allow %d = %v", i, ok)
+		}
+	}
+	if ok, _ := l.allow("carol"); !ok {
+		t.Error("Limit was shared between peers")
+	}
+	if _, wait := l.allow("bob"); wait != 100*time.Millisecond {
+		t.Errorf("Wait = %v", wait)
+	}
+	clock = clock.Add(100 * time.Millisecond)
+	if ok, _ := l.allow("bob"); !ok {
+		t.Error("Bucket did not refill")
+	}
+	if newLimiter(Rate{}) != nil {
+		t.Error("Zero rate is limited")
+	}
+}
+
+// TestRateLimits sends over the send and receive limits and checks
+// the errors returned.
+func TestRateLimits(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t), "carol": impltest.FreeAddr(t)})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, SendRate: Rate{Limit: 0.01, Burst: 2}})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir, ReceiveRate: Rate{Limit: 0.01}})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	if err := alice.Send("bob", []byte("one")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	var throttled *ThrottledError
+	if err := alice.Send("bob", []byte("two")); !errors.As(err, &throttled) ||
+		!strings.Contains(throttled.Reason, "receive rate limit") || throttled.RetryAfter <= 0 {
+		t.Errorf("Send over receive limit returned %v", err)
+	}
+	if err := alice.Send("bob", []byte("three")); !errors.As(err, &throttled) || throttled.Peer != "bob" || throttled.RetryAfter <= 0 {
+		t.Errorf("Send over send limit returned %v", err)
+	}
+
+	// The limit applies to alice's host, not her ID, so carol on
+	// the same host is limited too.  She is a legacy sender, so she
+	// is refused with an ERROR frame.
+	carol, err := NewMessageServiceWithOptions("carol", Options{Directory: dir, Legacy: true})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer carol.Close()
+	var rej *api.MessageRejected
+	if err := carol.Send("bob", []byte("one")); !errors.As(err, &rej) || !strings.Contains(rej.Msg, "receive rate limit") {
+		t.Errorf("Legacy send over receive limit returned %v", err)
+	}
+	if n := bob.Stats().Throttled; n != 2 {
+		t.Errorf("Throttled = %d", n)
+	}
+}
+
+// TestFlowControl fills a recipient's queue and ensures that a
+// sender using flow control holds back rather than being refused.
+func TestFlowControl(t *testing.T) {
+	alice, bob := newQueuePair(t, Reject)
+	alice.(*messageService).opts.FlowControl = true
+
+	if err := alice.Send("bob", []byte("fill")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	var throttled *ThrottledError
+	if err := alice.Send("bob", []byte("held")); !errors.As(err, &throttled) {
+		t.Errorf("Send to full queue returned %v", err)
+	}
+	if stats := bob.Stats(); stats.Rejected != 0 {
+		t.Errorf("Recipient rejected %d messages", stats.Rejected)
+	}
+
+	<-bob.Receiver()
+	if err := alice.Send("bob", []byte("after")); err != nil {
+		t.Errorf("Send after queue drained failed: %v", err)
+	}
+}
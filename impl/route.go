@@ -0,0 +1,132 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"cse586.messageservice/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultMaxHops is the hop limit used when Options.MaxHops is zero.
+const DefaultMaxHops = 8
+
+// DefaultRoute is the key in Options.Routes of the relay used for
+// recipients that have no route of their own and cannot be reached
+// directly.
+const DefaultRoute = "*"
+
+// ErrRoutingLoop is the reason a relay refuses a routed message
+// that has already passed through it.
+var ErrRoutingLoop = errors.New("routing loop")
+
+// ErrHopLimit is the reason a relay refuses a routed message that
+// has passed through as many relays as its sender allowed.
+var ErrHopLimit = errors.New("hop limit exceeded")
+
+// route sends msg toward its recipient.  A recipient with a static
+// route is reached through the relay it names.  Otherwise msg is
+// sent directly, and if that fails, through the relay that the
+// recipient's own routed messages last arrived from or the default
+// route.
+func (ms *messageService) route(msg *api.Message) error {
+	if hop, ok := ms.opts.Routes[msg.Recipient]; ok && hop != msg.Recipient {
+		return ms.sendVia(hop, msg)
+	}
+
+	if err := checkFrame(msg); err != nil {
+		return err
+	}
+	err := ms.transmit(msg.Recipient, msg)
+	var rej *api.MessageRejected
+	var throttled *ThrottledError
+	if err == nil || err == errClosed || errors.As(err, &rej) || errors.As(err, &throttled) {
+		return err
+	}
+	if hop, ok := ms.fallbackRoute(msg.Recipient); ok {
+		if viaErr := ms.sendVia(hop, msg); viaErr == nil || errors.As(viaErr, &rej) {
+			return viaErr
+		}
+	}
+	return err
+}
+
+// fallbackRoute returns the relay to try for recipient when it
+// cannot be reached directly.
+func (ms *messageService) fallbackRoute(recipient string) (string, bool) {
+	ms.mu.Lock()
+	hop, ok := ms.learned[recipient]
+	ms.mu.Unlock()
+	if !ok {
+		hop, ok = ms.opts.Routes[DefaultRoute]
+	}
+	if hop == ms.id || hop == recipient {
+		return "", false
+	}
+	return hop, ok
+}
+
+// sendVia sends msg to the relay hop.  A message that is not yet
+// routed is given the configured hop limit.
+func (ms *messageService) sendVia(hop string, msg *api.Message) error {
+	if msg.Ttl == 0 {
+		msg = proto.Clone(msg).(*api.Message)
+		msg.Ttl = uint32(ms.opts.MaxHops)
+	}
+	if err := checkFrame(msg); err != nil {
+		return err
+	}
+	if err := ms.transmit(hop, msg); err != nil {
+		return fmt.Errorf("via %s: %w", hop, err)
+	}
+	return nil
+}
+
+// checkHops returns the reason that this service must not pass on
+// msg, which is addressed to another service, if it has looped or run
+// out of hops.  A message that is not routed has no hop limit of its
+// own, so its path may be at most Options.MaxHops long.
+func (ms *messageService) checkHops(msg *api.Message) error {
+	if msg.Sender == ms.id {
+		return ErrRoutingLoop
+	}
+	for _, id := range msg.Path {
+		if id == ms.id {
+			return ErrRoutingLoop
+		}
+	}
+	if msg.Ttl == 1 || msg.Ttl == 0 && len(msg.Path) >= ms.opts.MaxHops {
+		return ErrHopLimit
+	}
+	return nil
+}
+
+// relay passes on a routed message addressed to another service,
+// after checking that it has not looped or run out of hops.
+func (ms *messageService) relay(msg *api.Message, remote net.Addr) error {
+	if err := ms.checkHops(msg); err != nil {
+		return err
+	}
+	ms.learn(msg)
+
+	msg.Ttl--
+	msg.Path = append(msg.Path, ms.id)
+	if err := ms.route(msg); err != nil {
+		ms.reportError(ForwardFailed, remote, err)
+		return err
+	}
+	ms.counters.forwarded.Add(1)
+	return nil
+}
+
+// learn records the relay that a routed message arrived from as the
+// way back to its sender.
+func (ms *messageService) learn(msg *api.Message) {
+	if len(msg.Path) == 0 {
+		return
+	}
+	ms.mu.Lock()
+	ms.learned[msg.Sender] = msg.Path[len(msg.Path)-1]
+	ms.mu.Unlock()
+}
@@ -0,0 +1,79 @@
+package impl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// newRoutedService creates a service whose directory holds addrs,
+// so that it can reach only the services listed there.
+func newRoutedService(t *testing.T, id string, addrs map[string]string, opts Options) Service {
+	t.Helper()
+	return impltest.Start(t, start(directory.NewStatic(addrs), opts), id)[0]
+}
+
+// TestRouteThroughRelay has alice and bob, who cannot reach each
+// other, exchange messages through a relay: alice through her
+// default route, and bob through the route learned from her message.
+func TestRouteThroughRelay(t *testing.T) {
+	aliceAddr, relayAddr, bobAddr, dead := impltest.FreeAddr(t), impltest.FreeAddr(t), impltest.FreeAddr(t), impltest.FreeAddr(t)
+	alice := newRoutedService(t, "alice",
+		map[string]string{"alice": aliceAddr, "relay": relayAddr, "bob": dead},
+		Options{Routes: map[string]string{DefaultRoute: "relay"}})
+	relay := newRoutedService(t, "relay",
+		map[string]string{"alice": aliceAddr, "relay": relayAddr, "bob": bobAddr},
+		Options{Relay: true})
+	bob := newRoutedService(t, "bob",
+		map[string]string{"alice": dead, "relay": relayAddr, "bob": bobAddr},
+		Options{})
+
+	if err := alice.Send("bob", []byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	rmsg := <-bob.Receiver()
+	if rmsg.Sender != "alice" || string(rmsg.Data) != "ping" || len(rmsg.Path) != 1 || rmsg.Path[0] != "relay" {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+
+	if err := bob.Send("alice", []byte("pong")); err != nil {
+		t.Fatalf("Reply failed: %v", err)
+	}
+	if rmsg := <-alice.Receiver(); rmsg.Sender != "bob" || string(rmsg.Data) != "pong" {
+		t.Errorf("Unexpected reply %v", rmsg)
+	}
+	if n := relay.Stats().Forwarded; n != 2 {
+		t.Errorf("Relay forwarded %d messages, expected 2", n)
+	}
+}
+
+// TestRoutingLimits ensures that messages caught in a routing loop
+// or exceeding the hop limit are refused.
+func TestRoutingLimits(t *testing.T) {
+	addrs := map[string]string{"alice": impltest.FreeAddr(t), "r1": impltest.FreeAddr(t), "r2": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t)}
+	newRoutedService(t, "r1", addrs, Options{Relay: true, Routes: map[string]string{"bob": "r2"}})
+	newRoutedService(t, "r2", addrs, Options{Relay: true, Routes: map[string]string{"bob": "r1"}})
+
+	for _, tc := range []struct {
+		name    string
+		maxHops int
+		want    error
+	}{
+		{"Loop", 0, ErrRoutingLoop},
+		{"HopLimit", 2, ErrHopLimit},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice := newRoutedService(t, "alice", addrs,
+				Options{Routes: map[string]string{"bob": "r1"}, MaxHops: tc.maxHops})
+			err := alice.Send("bob", []byte("hello"))
+			var rej *api.MessageRejected
+			if !errors.As(err, &rej) || !strings.Contains(rej.Msg, tc.want.Error()) {
+				t.Errorf("Send returned %v, expected %v", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+// Package rpc provides request/response calls on top of an
+// impl.Service.  Calls and replies are ordinary messages holding an
+// api.RPC in an envelope (see package envelope), so an Endpoint can
+// share its service with plain one-way messages, which it passes
+// through to its own Receiver channel.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/envelope"
+)
+
+// DefaultTimeout bounds a Call whose context has no deadline.
+const DefaultTimeout = 10 * time.Second
+
+// ErrClosed is returned by calls that are outstanding when the
+// Endpoint is closed, and by calls made after that.
+var ErrClosed = errors.New("rpc endpoint closed")
+
+// RemoteError is returned by Call when the handler on the remote
+// endpoint failed, or the remote endpoint has no handler for the
+// method.
+type RemoteError struct {
+	Method string
+	Msg    string
+}
+
+func (err *RemoteError) Error() string {
+	return fmt.Sprintf("rpc %s: %s", err.Method, err.Msg)
+}
+
+// Handler handles a call from sender.  The payload it returns is
+// sent back as the reply; if it returns an error instead, the caller
+// receives a RemoteError holding its text.  The context is canceled
+// when the Endpoint is closed.
+type Handler func(ctx context.Context, sender string, payload []byte) ([]byte, error)
+
+// Endpoint makes and answers calls over a MessageService.  It reads
+// every message from the service's Receiver channel, so the
+// application must use the Endpoint's Receiver instead.
+//
+// Plain messages wait for the application in a queue as long as the
+// service's.  Messages that arrive while it is full are dropped and
+// counted by Dropped, so that an application that does not read its
+// Receiver never delays calls and replies.
+type Endpoint struct {
+	ms       impl.Service
+	receiver chan *api.Message
+	dropped  atomic.Uint64
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	pending  map[uint64]*call
+	nextID   uint64
+	closed   bool
+}
+
+// call is an outstanding Call awaiting its reply.
+type call struct {
+	recipient string
+	reply     chan *api.RPC
+}
+
+// New creates an Endpoint on ms.  The Endpoint owns ms from then on,
+// and closes it when it is closed.
+func New(ms impl.Service) *Endpoint {
+	ctx, cancel := context.WithCancel(context.Background())
+	queueLen := cap(ms.Receiver())
+	if queueLen == 0 {
+		queueLen = 1
+	}
+	e := &Endpoint{
+		ms:       ms,
+		receiver: make(chan *api.Message, queueLen),
+		ctx:      ctx,
+		cancel:   cancel,
+		handlers: make(map[string]Handler),
+		pending:  make(map[uint64]*call),
+	}
+	e.wg.Add(1)
+	go e.dispatch()
+	return e
+}
+
+// Handle registers h to answer calls to method, replacing any
+// handler already registered for it.  A nil h removes the handler.
+func (e *Endpoint) Handle(method string, h Handler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if h == nil {
+		delete(e.handlers, method)
+	} else {
+		e.handlers[method] = h
+	}
+}
+
+// Call calls method on recipient's Endpoint with payload and waits
+// for the reply.  It gives up when ctx is done, or after
+// DefaultTimeout if ctx has no deadline.  Any number of calls may be
+// outstanding at once.
+func (e *Endpoint) Call(ctx context.Context, recipient, method string, payload []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	c := &call{recipient: recipient, reply: make(chan *api.RPC, 1)}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, ErrClosed
+	}
+	e.nextID++
+	id := e.nextID
+	e.pending[id] = c
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.pending, id)
+		e.mu.Unlock()
+	}()
+
+	err := e.send(recipient, &api.RPC{Id: id, Method: method, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case reply, ok := <-c.reply:
+		if !ok {
+			return nil, ErrClosed
+		}
+		if reply.Error != "" {
+			return nil, &RemoteError{method, reply.Error}
+		}
+		return reply.Payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send sends a plain one-way message to recipient.
+func (e *Endpoint) Send(recipient string, data []byte) error {
+	return e.ms.Send(recipient, data)
+}
+
+// SendMessage sends a one-way message with headers, as
+// impl.Service.SendMessage does.
+func (e *Endpoint) SendMessage(msg *api.Message) error {
+	return e.ms.SendMessage(msg)
+}
+
+// Receiver returns a channel that receives the messages that are not
+// calls or replies.  It is closed when the underlying service's
+// Receiver channel is.
+func (e *Endpoint) Receiver() <-chan *api.Message {
+	return e.receiver
+}
+
+// Dropped returns the number of plain messages dropped because the
+// Receiver channel was full.
+func (e *Endpoint) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Close fails any outstanding calls with ErrClosed, cancels the
+// contexts of running handlers, and closes the underlying service.
+func (e *Endpoint) Close() error {
+	e.shutdown()
+	err := e.ms.Close()
+	e.wg.Wait()
+	return err
+}
+
+// shutdown marks the Endpoint closed and fails outstanding calls.
+func (e *Endpoint) shutdown() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	e.cancel()
+	for id, c := range e.pending {
+		close(c.reply)
+		delete(e.pending, id)
+	}
+}
+
+// send sends rpc to recipient in an envelope.
+func (e *Endpoint) send(recipient string, rpc *api.RPC) error {
+	return envelope.Send(e.ms, recipient, envelope.RPC, rpc)
+}
+
+// dispatch reads messages from the service until its Receiver
+// channel is closed, passing plain messages on to the Endpoint's
+// Receiver.
+func (e *Endpoint) dispatch() {
+	defer e.wg.Done()
+	defer close(e.receiver)
+	defer e.shutdown()
+
+	for msg := range e.ms.Receiver() {
+		rpc := &api.RPC{}
+		ok := envelope.Open(msg, envelope.RPC, rpc)
+		switch {
+		case !ok:
+			select {
+			case e.receiver <- msg:
+			default:
+				e.dropped.Add(1)
+			}
+		case rpc.Reply:
+			e.complete(msg.Sender, rpc)
+		default:
+			e.wg.Add(1)
+			go e.serve(msg.Sender, rpc)
+		}
+	}
+}
+
+// complete hands a reply to the call awaiting it.  Replies to calls
+// that have already given up, or from services other than the one
+// called, are discarded.
+func (e *Endpoint) complete(sender string, rpc *api.RPC) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.pending[rpc.Id]; ok && c.recipient == sender {
+		delete(e.pending, rpc.Id)
+		c.reply <- rpc
+	}
+}
+
+// serve runs the handler for a call and sends its reply.
+func (e *Endpoint) serve(sender string, rpc *api.RPC) {
+	defer e.wg.Done()
+
+	e.mu.Lock()
+	h, ok := e.handlers[rpc.Method]
+	e.mu.Unlock()
+
+	reply := &api.RPC{Id: rpc.Id, Method: rpc.Method, Reply: true}
+	if !ok {
+		reply.Error = "unknown method"
+	} else if payload, err := h(e.ctx, sender, rpc.Payload); err != nil {
+		reply.Error = err.Error()
+		if reply.Error == "" {
+			reply.Error = "handler failed"
+		}
+	} else {
+		reply.Payload = payload
+	}
+	// There is no one to tell if the reply cannot be sent; the
+	// caller will time out.
+	e.send(sender, reply)
+}
@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/impltest"
+	"google.golang.org/protobuf/proto"
+)
+
+// newPair creates Endpoints for alice and bob on loopback addresses,
+// with services configured by opts.
+func newPair(t *testing.T, opts impl.Options) (*Endpoint, *Endpoint) {
+	t.Helper()
+	opts.Directory = impltest.Directory(t, "alice", "bob")
+	endpoints := impltest.Start(t, func(id string) (*Endpoint, error) {
+		ms, err := impl.NewMessageServiceWithOptions(id, opts)
+		if err != nil {
+			return nil, err
+		}
+		return New(ms), nil
+	}, "alice", "bob")
+	return endpoints[0], endpoints[1]
+}
+
+// TestCall makes concurrent calls alongside plain messages and
+// checks that each gets its own reply.
+func TestCall(t *testing.T) {
+	alice, bob := newPair(t, impl.Options{})
+	bob.Handle("echo", func(ctx context.Context, sender string, payload []byte) ([]byte, error) {
+		return append([]byte(sender+":"), payload...), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprintf("alice:%d", i)
+			reply, err := alice.Call(context.Background(), "bob", "echo", []byte(fmt.Sprint(i)))
+			if err != nil || string(reply) != want {
+				t.Errorf("Call returned %q, %v; expected %q", reply, err, want)
+			}
+		}(i)
+	}
+	// A plain message is never taken for a call, even if its
+	// data is one.
+	lookalike, _ := proto.Marshal(&api.RPC{Id: 1, Method: "echo"})
+	if err := alice.Send("bob", lookalike); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	wg.Wait()
+	if msg := <-bob.Receiver(); string(msg.Data) != string(lookalike) {
+		t.Errorf("Unexpected plain message %v", msg)
+	}
+}
+
+// TestCallErrors checks the errors returned for failing handlers,
+// unknown methods, and timeouts.
+func TestCallErrors(t *testing.T) {
+	alice, bob := newPair(t, impl.Options{})
+	bob.Handle("fail", func(ctx context.Context, sender string, payload []byte) ([]byte, error) {
+		return nil, errors.New("no good")
+	})
+	bob.Handle("slow", func(ctx context.Context, sender string, payload []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	var rerr *RemoteError
+	if _, err := alice.Call(context.Background(), "bob", "fail", nil); !errors.As(err, &rerr) || rerr.Msg != "no good" {
+		t.Errorf("Failing call returned %v", err)
+	}
+	if _, err := alice.Call(context.Background(), "bob", "nonesuch", nil); !errors.As(err, &rerr) || rerr.Msg != "unknown method" {
+		t.Errorf("Call to unknown method returned %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := alice.Call(ctx, "bob", "slow", nil); err != context.DeadlineExceeded {
+		t.Errorf("Slow call returned %v", err)
+	}
+
+	alice.Close()
+	if _, err := alice.Call(context.Background(), "bob", "fail", nil); err != ErrClosed {
+		t.Errorf("Call after Close returned %v", err)
+	}
+}
+
+// TestUnreadReceiver ensures that plain messages the application does
+// not read are dropped rather than holding up calls.
+func TestUnreadReceiver(t *testing.T) {
+	alice, bob := newPair(t, impl.Options{ReceiveBuffer: 1})
+	alice.Handle("echo", func(ctx context.Context, sender string, payload []byte) ([]byte, error) {
+		return payload, nil
+	})
+	for i := 0; i < 3; i++ {
+		if err := alice.Send("bob", []byte("unread")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if reply, err := bob.Call(ctx, "alice", "echo", []byte("ping")); err != nil || string(reply) != "ping" {
+		t.Fatalf("Call behind unread messages returned %q, %v", reply, err)
+	}
+	if n := bob.Dropped(); n != 2 {
+		t.Errorf("Dropped %d messages, expected 2", n)
+	}
+	if msg := <-bob.Receiver(); string(msg.Data) != "unread" {
+		t.Errorf("Unexpected plain message %v", msg)
+	}
+}
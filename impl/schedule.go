@@ -0,0 +1,167 @@
+package impl
+
+import (
+	"math"
+
+	"cse586.messageservice/api"
+)
+
+// DefaultStarvationLimit is the starvation limit used when
+// Options.StarvationLimit is zero.
+const DefaultStarvationLimit = 16
+
+// DefaultMaxSendsPerPeer is the limit on concurrent sends to one
+// recipient used when Options.MaxSendsPerPeer is zero.
+const DefaultMaxSendsPerPeer = 8
+
+// Messages are sent to each recipient in priority order, so that a
+// heartbeat does not wait behind a backlog of bulk messages.  Each
+// recipient with messages waiting has a peerQueue, and up to
+// Options.MaxSendsPerPeer goroutines that each send one of its
+// messages at a time; the queue goes away when it empties and its
+// goroutines finish.  Priority orders only the messages still
+// waiting: a CONTROL message that arrives while every goroutine is
+// busy starts one more, rather than waiting for a send already in
+// flight.  To keep low-priority messages from waiting forever, once
+// Options.StarvationLimit messages have been sent ahead of a waiting
+// lower-priority message, the oldest such message is sent next.
+
+// numPriorities is the number of distinct priorities.
+const numPriorities = 4
+
+// rank orders priorities from lowest (0) to highest.
+func rank(p api.Priority) int {
+	switch p {
+	case api.Priority_LOW:
+		return 0
+	case api.Priority_HIGH:
+		return 2
+	case api.Priority_CONTROL:
+		return 3
+	}
+	return 1
+}
+
+// sendRequest is a message waiting to be sent.
+type sendRequest struct {
+	msg  *api.Message
+	seq  uint64
+	done chan error
+}
+
+// peerQueue holds the messages waiting to be sent to one recipient,
+// by rank.
+type peerQueue struct {
+	queues [numPriorities][]*sendRequest
+	// streak counts the messages sent while a lower-priority
+	// message was waiting.
+	streak int
+	// active is the number of goroutines sending its messages.
+	active int
+}
+
+// transmit sends msg to recipient once the messages of higher
+// priority queued for it have been sent, and returns the result.
+func (ms *messageService) transmit(recipient string, msg *api.Message) error {
+	req := &sendRequest{msg: msg, done: make(chan error, 1)}
+
+	ms.schedMu.Lock()
+	ms.sendSeq++
+	req.seq = ms.sendSeq
+	p, ok := ms.peers[recipient]
+	if !ok {
+		p = &peerQueue{}
+		ms.peers[recipient] = p
+	}
+	r := rank(msg.Priority)
+	p.queues[r] = append(p.queues[r], req)
+	if p.active < ms.maxSends() || r == rank(api.Priority_CONTROL) {
+		if !ms.begin() {
+			p.queues[r] = p.queues[r][:len(p.queues[r])-1]
+			if p.active == 0 {
+				delete(ms.peers, recipient)
+			}
+			ms.schedMu.Unlock()
+			return errClosed
+		}
+		p.active++
+		go ms.runPeer(recipient, p)
+	}
+	ms.schedMu.Unlock()
+
+	return <-req.done
+}
+
+// maxSends returns the limit on concurrent sends to one recipient.
+func (ms *messageService) maxSends() int {
+	if ms.opts.MaxSendsPerPeer < 0 {
+		return math.MaxInt
+	}
+	return ms.opts.MaxSendsPerPeer
+}
+
+// runPeer sends the messages queued for recipient until there are
+// none left, or until there are more goroutines sending them than
+// the limit allows and no CONTROL message is waiting.
+func (ms *messageService) runPeer(recipient string, p *peerQueue) {
+	defer ms.end()
+	for {
+		ms.schedMu.Lock()
+		var req *sendRequest
+		if p.active <= ms.maxSends() || len(p.queues[rank(api.Priority_CONTROL)]) > 0 {
+			req = p.next(ms.opts.StarvationLimit)
+		}
+		if req == nil {
+			p.active--
+			if p.active == 0 {
+				delete(ms.peers, recipient)
+			}
+			ms.schedMu.Unlock()
+			return
+		}
+		ms.schedMu.Unlock()
+
+		if ms.isClosed() {
+			req.done <- errClosed
+			continue
+		}
+		req.done <- ms.transmitNow(recipient, req.msg)
+	}
+}
+
+// next removes and returns the message to send next, or nil if
+// there is none.
+func (p *peerQueue) next(limit int) *sendRequest {
+	top := -1
+	for r := numPriorities - 1; r >= 0; r-- {
+		if len(p.queues[r]) > 0 {
+			top = r
+			break
+		}
+	}
+	if top < 0 {
+		return nil
+	}
+
+	// Find the oldest message waiting below top.
+	starved := -1
+	for r := 0; r < top; r++ {
+		if len(p.queues[r]) > 0 && (starved < 0 || p.queues[r][0].seq < p.queues[starved][0].seq) {
+			starved = r
+		}
+	}
+
+	pick := top
+	switch {
+	case starved < 0:
+		p.streak = 0
+	case p.streak >= limit:
+		pick = starved
+		p.streak = 0
+	default:
+		p.streak++
+	}
+	req := p.queues[pick][0]
+	p.queues[pick] = p.queues[pick][1:]
+	return req
+}
@@ -0,0 +1,189 @@
+package impl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestSchedulerOrder checks that messages are sent in priority
+// order, except that a starved low-priority message is let through.
+func TestSchedulerOrder(t *testing.T) {
+	p := &peerQueue{}
+	seq := uint64(0)
+	add := func(pri api.Priority, name string) {
+		seq++
+		r := rank(pri)
+		p.queues[r] = append(p.queues[r], &sendRequest{msg: &api.Message{Data: []byte(name)}, seq: seq})
+	}
+	add(api.Priority_LOW, "L")
+	add(api.Priority_NORMAL, "N")
+	for i := 3; i <= 7; i++ {
+		add(api.Priority_HIGH, fmt.Sprint("H", i))
+	}
+	add(api.Priority_CONTROL, "C")
+
+	var order []string
+	for req := p.next(2); req != nil; req = p.next(2) {
+		order = append(order, string(req.msg.Data))
+	}
+	if got, want := strings.Join(order, " "), "C H3 L H4 H5 N H6 H7"; got != want {
+		t.Errorf("Sent %s, expected %s", got, want)
+	}
+}
+
+// TestPriorityDelivered ensures that a message's priority reaches
+// its recipient, and that Options.Priority applies to Send.
+func TestPriorityDelivered(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t)})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, Priority: api.Priority_LOW})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	if err := alice.SendMessage(&api.Message{Recipient: "bob", Priority: api.Priority_CONTROL}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := alice.Send("bob", []byte("bulk")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	for _, want := range []api.Priority{api.Priority_CONTROL, api.Priority_LOW} {
+		if rmsg := <-bob.Receiver(); rmsg.Priority != want {
+			t.Errorf("Received priority %v, expected %v", rmsg.Priority, want)
+		}
+	}
+}
+
+// heldConn is a connection accepted by holdListener, with the frame
+// read from it.
+type heldConn struct {
+	conn net.Conn
+	msg  *api.Message
+}
+
+// holdListener accepts connections on a new listener, reads one frame
+// from each, and hands them over without replying, so that their
+// senders wait until the test closes them.
+func holdListener(t *testing.T) (string, <-chan heldConn) {
+	t.Helper()
+	l := impltest.Listen(t)
+	held := make(chan heldConn, 8)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				msg, err := newFrameReader(conn).readFrame()
+				if err != nil {
+					conn.Close()
+					return
+				}
+				held <- heldConn{conn, msg}
+			}()
+		}
+	}()
+	return l.Addr().String(), held
+}
+
+// nextHeld returns the next connection from held, failing the test if
+// none arrives promptly.
+func nextHeld(t *testing.T, held <-chan heldConn) heldConn {
+	t.Helper()
+	select {
+	case h := <-held:
+		return h
+	case <-time.After(2 * time.Second):
+		t.Fatal("No connection arrived")
+		return heldConn{}
+	}
+}
+
+// TestConcurrentSends ensures that messages sent to one recipient at
+// once are in flight together.
+func TestConcurrentSends(t *testing.T) {
+	addr, held := holdListener(t)
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": addr})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir, ReplyTimeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() { errs <- alice.Send("bob", []byte("hello")) }()
+	}
+	// No message is answered until all three are in flight.
+	var conns []heldConn
+	for i := 0; i < 3; i++ {
+		conns = append(conns, nextHeld(t, held))
+	}
+	for _, h := range conns {
+		h.conn.Close()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Send failed: %v", err)
+		}
+	}
+}
+
+// TestControlBypass ensures that sends beyond MaxSendsPerPeer wait,
+// except for a CONTROL message.
+func TestControlBypass(t *testing.T) {
+	addr, held := holdListener(t)
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": addr})
+	alice, err := NewMessageServiceWithOptions("alice", Options{
+		Directory:       dir,
+		ReplyTimeout:    10 * time.Second,
+		MaxSendsPerPeer: 1,
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+
+	errs := make(chan error, 3)
+	go func() { errs <- alice.Send("bob", []byte("first")) }()
+	first := nextHeld(t, held)
+	go func() { errs <- alice.Send("bob", []byte("second")) }()
+	go func() {
+		errs <- alice.SendMessage(&api.Message{Recipient: "bob", Priority: api.Priority_CONTROL})
+	}()
+
+	// The CONTROL message does not wait for the first to finish,
+	// but the second does.
+	control := nextHeld(t, held)
+	if control.msg.Priority != api.Priority_CONTROL {
+		t.Errorf("Received %q while the first message was in flight", control.msg.Data)
+	}
+	control.conn.Close()
+	if err := <-errs; err != nil {
+		t.Errorf("Send failed: %v", err)
+	}
+	first.conn.Close()
+	second := nextHeld(t, held)
+	if string(second.msg.Data) != "second" {
+		t.Errorf("Received %v, expected the second message", second.msg)
+	}
+	second.conn.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Send failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package trace propagates trace context between services in the
+// headers of messages, in the style of W3C Trace Context, and records
+// spans for an Exporter.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cse586.messageservice/api"
+)
+
+// Header is the message header that carries a SpanContext, in the
+// format of the W3C traceparent header.
+const Header = "traceparent"
+
+// TraceID identifies a trace, which is the set of spans that follow
+// from one operation.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether id is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether id is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// MarshalText encodes id in hexadecimal.
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// MarshalText encodes id in hexadecimal, or as "" if it is zero.
+func (id SpanID) MarshalText() ([]byte, error) {
+	if !id.IsValid() {
+		return nil, nil
+	}
+	return []byte(id.String()), nil
+}
+
+// SpanContext is the part of a span that is propagated to other
+// services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled reports whether the trace is being recorded.
+	Sampled bool
+}
+
+// IsValid reports whether sc has both a trace and a span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// ErrMalformed is returned by Parse for a value that is not a valid
+// traceparent.
+var ErrMalformed = errors.New("malformed traceparent")
+
+// String formats sc as a traceparent, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func (sc SpanContext) String() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Parse parses a traceparent.  Versions other than 00 are accepted
+// if they begin with the fields of version 00, as the specification
+// requires.
+func Parse(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("%w: %q", ErrMalformed, s)
+	}
+	var version, flags [1]byte
+	for _, field := range []struct {
+		dst []byte
+		src string
+	}{
+		{version[:], parts[0]},
+		{sc.TraceID[:], parts[1]},
+		{sc.SpanID[:], parts[2]},
+		{flags[:], parts[3]},
+	} {
+		if len(field.src) != 2*len(field.dst) || strings.ToLower(field.src) != field.src {
+			return SpanContext{}, fmt.Errorf("%w: %q", ErrMalformed, s)
+		}
+		if _, err := hex.Decode(field.dst, []byte(field.src)); err != nil {
+			return SpanContext{}, fmt.Errorf("%w: %q", ErrMalformed, s)
+		}
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrMalformed, s)
+	}
+	sc.Sampled = flags[0]&1 != 0
+	return sc, nil
+}
+
+// FromMessage returns the span context carried by msg, and whether
+// it carries a valid one.
+func FromMessage(msg *api.Message) (SpanContext, bool) {
+	sc, err := Parse(msg.Header(Header))
+	return sc, err == nil
+}
+
+// Inject sets the trace header of msg to sc.
+func Inject(msg *api.Message, sc SpanContext) {
+	msg.SetHeader(Header, sc.String())
+}
+
+// newTraceID and newSpanID return random IDs.
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,144 @@
+package trace
+
+import (
+	"sync"
+	"time"
+)
+
+// SpanKind describes the role of a span in a message exchange.
+type SpanKind int
+
+const (
+	// Internal is a span within one service.
+	Internal SpanKind = iota
+	// Producer is a span that sends a message.
+	Producer
+	// Consumer is a span that receives a message.
+	Consumer
+)
+
+var spanKindNames = [...]string{
+	Internal: "internal",
+	Producer: "producer",
+	Consumer: "consumer",
+}
+
+func (k SpanKind) String() string {
+	if k < 0 || int(k) >= len(spanKindNames) {
+		return "unknown"
+	}
+	return spanKindNames[k]
+}
+
+// MarshalText encodes k as its name.
+func (k SpanKind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+// SpanData is the record of a span that is given to an Exporter.
+type SpanData struct {
+	Name    string    `json:"name"`
+	Kind    SpanKind  `json:"kind"`
+	Service string    `json:"service,omitempty"`
+	TraceID TraceID   `json:"trace_id"`
+	SpanID  SpanID    `json:"span_id"`
+	Parent  SpanID    `json:"parent_id"` // zero, encoded as "", for a root span
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Attributes describe the operation, such as the peer that a
+	// message was sent to.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Error is the error that the operation ended with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Span is a timed operation within a trace.  A span is created by
+// Tracer.Start and exported when Finish is called.  The methods of a
+// nil *Span do nothing, so that callers need not check whether
+// tracing is enabled.
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+
+	mu       sync.Mutex
+	data     SpanData
+	finished bool
+}
+
+// Context returns the span context to propagate to the operations
+// that s causes.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{s.data.TraceID, s.data.SpanID, s.sampled}
+}
+
+// SetAttribute sets the attribute key of s to value.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// Finish ends s with the result err, and exports it if its trace is
+// sampled.  Calls after the first do nothing.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.finished = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+	if s.sampled {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+// Tracer creates spans for one service and hands them to an
+// Exporter when they end.
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer returns a Tracer that names service in its spans and
+// exports them to exporter.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service, exporter}
+}
+
+// Start begins a span.  If parent is valid the span joins its trace,
+// and is sampled if the parent is; otherwise it begins a new, sampled
+// trace.  A nil Tracer returns a nil Span.
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, data: SpanData{
+		Name:    name,
+		Kind:    kind,
+		Service: t.service,
+		SpanID:  newSpanID(),
+		Start:   time.Now(),
+	}}
+	if parent.IsValid() {
+		s.data.TraceID, s.data.Parent, s.sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		s.data.TraceID, s.sampled = newTraceID(), true
+	}
+	return s
+}
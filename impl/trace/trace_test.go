@@ -0,0 +1,77 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestParse checks traceparent parsing and formatting.
+func TestParse(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := Parse(valid)
+	if err != nil || !sc.Sampled || sc.String() != valid {
+		t.Errorf("Parse(%q) = %v, %v", valid, sc, err)
+	}
+	if sc, err := Parse("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); err != nil || sc.Sampled {
+		t.Errorf("Future version: %v, %v", sc, err)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, err := Parse(bad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse(%q) returned %v", bad, err)
+		}
+	}
+}
+
+// TestTracer checks that child spans join their parent's trace and
+// that unsampled traces are not exported.
+func TestTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer("alice", NewJSONExporter(&buf))
+
+	root := tracer.Start("root", Internal, SpanContext{})
+	child := tracer.Start("child", Producer, root.Context())
+	child.SetAttribute("peer", "bob")
+	child.Finish(errors.New("boom"))
+	child.Finish(nil)
+	root.Finish(nil)
+	unsampled := root.Context()
+	unsampled.Sampled = false
+	tracer.Start("skipped", Internal, unsampled).Finish(nil)
+
+	var nilTracer *Tracer
+	nilTracer.Start("none", Internal, SpanContext{}).Finish(nil)
+
+	dec := json.NewDecoder(&buf)
+	var spans []map[string]interface{}
+	for dec.More() {
+		var span map[string]interface{}
+		if err := dec.Decode(&span); err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, span)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("Exported %d spans, expected 2", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if c["name"] != "child" || c["kind"] != "producer" || c["error"] != "boom" || c["service"] != "alice" {
+		t.Errorf("Unexpected child span %v", c)
+	}
+	if c["trace_id"] != r["trace_id"] || c["parent_id"] != r["span_id"] || r["parent_id"] != "" {
+		t.Errorf("Spans are not related: %v, %v", c, r)
+	}
+	if attrs, _ := c["attributes"].(map[string]interface{}); attrs["peer"] != "bob" {
+		t.Errorf("Unexpected attributes %v", c["attributes"])
+	}
+}
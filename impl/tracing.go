@@ -0,0 +1,48 @@
+package impl
+
+import (
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl/trace"
+)
+
+// Span names used when Options.Tracer is set.
+const (
+	sendSpanName    = "messageservice.send"
+	receiveSpanName = "messageservice.receive"
+)
+
+// startSendSpan begins the span of sending msg, as a child of the
+// span context that msg carries, if any, and replaces that context
+// with the new span's so that the recipient continues the trace.  It
+// returns nil if tracing is disabled.
+func (ms *messageService) startSendSpan(msg *api.Message) *trace.Span {
+	if ms.opts.Tracer == nil {
+		return nil
+	}
+	parent, _ := trace.FromMessage(msg)
+	span := ms.opts.Tracer.Start(sendSpanName, trace.Producer, parent)
+	span.SetAttribute("peer", msg.Recipient)
+	if msg.Id != "" {
+		span.SetAttribute("message.id", msg.Id)
+	}
+	trace.Inject(msg, span.Context())
+	return span
+}
+
+// startReceiveSpan is like startSendSpan, for a message that has
+// arrived for this service.  The application sees the receive span's
+// context in the message, and can pass it on by copying the header
+// to the messages that it sends in response.
+func (ms *messageService) startReceiveSpan(msg *api.Message) *trace.Span {
+	if ms.opts.Tracer == nil {
+		return nil
+	}
+	parent, _ := trace.FromMessage(msg)
+	span := ms.opts.Tracer.Start(receiveSpanName, trace.Consumer, parent)
+	span.SetAttribute("peer", msg.Sender)
+	if msg.Id != "" {
+		span.SetAttribute("message.id", msg.Id)
+	}
+	trace.Inject(msg, span.Context())
+	return span
+}
@@ -0,0 +1,74 @@
+package impl
+
+import (
+	"testing"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+	"cse586.messageservice/impl/trace"
+)
+
+// TestTracePropagation passes a message from alice to bob, who passes
+// it on to carol, and ensures that the four spans form one trace.
+func TestTracePropagation(t *testing.T) {
+	exporter := &trace.MemoryExporter{}
+	dir := directory.NewStatic(map[string]string{"alice": impltest.FreeAddr(t), "bob": impltest.FreeAddr(t), "carol": impltest.FreeAddr(t)})
+	services := make(map[string]Service)
+	for _, id := range []string{"alice", "bob", "carol"} {
+		ms, err := NewMessageServiceWithOptions(id, Options{
+			Directory: dir,
+			Tracer:    trace.NewTracer(id, exporter),
+		})
+		if err != nil {
+			t.Fatalf("Could not create service: %v", err)
+		}
+		defer ms.Close()
+		services[id] = ms
+	}
+
+	if err := services["alice"].Send("bob", []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	rmsg := <-services["bob"].Receiver()
+	next := &api.Message{Recipient: "carol", Data: rmsg.Data}
+	next.SetHeader(trace.Header, rmsg.Header(trace.Header))
+	if err := services["bob"].SendMessage(next); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	rmsg = <-services["carol"].Receiver()
+	sc, ok := trace.FromMessage(rmsg)
+	if !ok {
+		t.Fatalf("Message carries no trace context: %v", rmsg.Headers)
+	}
+
+	// Spans end in order, except that a receive span may end
+	// before the send span that caused it.
+	byService := make(map[string][]trace.SpanData)
+	for _, span := range exporter.Spans() {
+		byService[span.Service] = append(byService[span.Service], span)
+	}
+	if len(byService["alice"]) != 1 || len(byService["bob"]) != 2 || len(byService["carol"]) != 1 {
+		t.Fatalf("Unexpected spans %v", exporter.Spans())
+	}
+	send1, recv1 := byService["alice"][0], byService["bob"][0]
+	send2, recv2 := byService["bob"][1], byService["carol"][0]
+	chain := []trace.SpanData{send1, recv1, send2, recv2}
+	for i, span := range chain {
+		if span.TraceID != sc.TraceID {
+			t.Errorf("Span %d is in trace %v, expected %v", i, span.TraceID, sc.TraceID)
+		}
+		if i > 0 && span.Parent != chain[i-1].SpanID {
+			t.Errorf("Span %d has parent %v, expected %v", i, span.Parent, chain[i-1].SpanID)
+		}
+	}
+	if send1.Parent.IsValid() || send1.Kind != trace.Producer || recv1.Kind != trace.Consumer {
+		t.Errorf("Unexpected first spans %v, %v", send1, recv1)
+	}
+	if send2.Name != sendSpanName || send2.Attributes["peer"] != "carol" || send2.Attributes["message.id"] == "" {
+		t.Errorf("Unexpected send span %v", send2)
+	}
+	if recv2.SpanID != sc.SpanID {
+		t.Error("Received message does not carry the receive span")
+	}
+}
@@ -0,0 +1,106 @@
+package typed
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Registry maps Go types to the names and codecs with which their
+// values are sent.  Sender and receiver must register a type under
+// the same name.
+type Registry struct {
+	mu     sync.RWMutex
+	byName map[string]*registration
+	byType map[reflect.Type]*registration
+	codecs map[string]Codec
+}
+
+// registration is a type registered with a Registry.  typ is never a
+// pointer type; values of typ and of pointers to it are both sent
+// under name.
+type registration struct {
+	name  string
+	typ   reflect.Type
+	codec Codec
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		byName: make(map[string]*registration),
+		byType: make(map[reflect.Type]*registration),
+		codecs: make(map[string]Codec),
+	}
+}
+
+// Register registers the type of sample, which may be a value or a
+// pointer, to be sent with codec.  The type is named by its protobuf
+// full name if it is a protobuf message, and by its Go package path
+// and name otherwise.
+func (r *Registry) Register(sample any, codec Codec) error {
+	var name string
+	if m, ok := sample.(proto.Message); ok {
+		name = string(m.ProtoReflect().Descriptor().FullName())
+	} else {
+		typ := baseType(reflect.TypeOf(sample))
+		if typ == nil || typ.Name() == "" {
+			return fmt.Errorf("cannot register unnamed type %T", sample)
+		}
+		name = typ.PkgPath() + "." + typ.Name()
+	}
+	return r.RegisterName(name, sample, codec)
+}
+
+// RegisterName registers the type of sample under name.  A type may
+// be registered only once, and a name used only once.
+func (r *Registry) RegisterName(name string, sample any, codec Codec) error {
+	typ := baseType(reflect.TypeOf(sample))
+	if typ == nil {
+		return fmt.Errorf("cannot register nil")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byName[name]; ok {
+		return fmt.Errorf("type name %q already registered", name)
+	}
+	if reg, ok := r.byType[typ]; ok {
+		return fmt.Errorf("type %v already registered as %q", typ, reg.name)
+	}
+	reg := &registration{name, typ, codec}
+	r.byName[name] = reg
+	r.byType[typ] = reg
+	r.codecs[codec.ContentType()] = codec
+	return nil
+}
+
+// lookupType returns the registration for the type of v.
+func (r *Registry) lookupType(v any) (*registration, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.byType[baseType(reflect.TypeOf(v))]
+	return reg, ok
+}
+
+// lookupName returns the registration for name and the codec for
+// contentType.
+func (r *Registry) lookupName(name, contentType string) (*registration, Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.byName[name]
+	if !ok {
+		return nil, nil, false
+	}
+	codec, ok := r.codecs[contentType]
+	return reg, codec, ok
+}
+
+// baseType strips pointers from typ.
+func baseType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ
+}
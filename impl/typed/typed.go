@@ -0,0 +1,179 @@
+// Package typed sends and receives Go values over an impl.Service.
+// Types are registered in a Registry with the Codec that encodes
+// them, and each value travels in an api.Typed envelope (see package
+// envelope) naming its type and codec.  A Messenger decodes incoming
+// values and passes each to the handler registered for its type.
+package typed
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/envelope"
+)
+
+// ErrNotTyped is returned by Decode for messages that do not hold a
+// typed value.
+var ErrNotTyped = errors.New("message does not hold a typed value")
+
+// Messenger sends typed values and dispatches received ones to
+// handlers.  It reads every message from its service's Receiver
+// channel, so the application must use the Messenger's Receiver
+// instead.
+type Messenger struct {
+	ms       impl.Service
+	reg      *Registry
+	receiver chan *api.Message
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+
+	mu       sync.RWMutex
+	handlers map[reflect.Type]func(sender string, v reflect.Value)
+}
+
+// New creates a Messenger on ms that encodes and decodes the types
+// registered in reg.  The Messenger owns ms from then on, and closes
+// it when it is closed.
+func New(ms impl.Service, reg *Registry) *Messenger {
+	m := &Messenger{
+		ms:       ms,
+		reg:      reg,
+		receiver: make(chan *api.Message, cap(ms.Receiver())),
+		done:     make(chan struct{}),
+		handlers: make(map[reflect.Type]func(string, reflect.Value)),
+	}
+	m.wg.Add(1)
+	go m.dispatch()
+	return m
+}
+
+// SendTyped encodes v with the codec registered for its type and
+// sends it to recipient.
+func (m *Messenger) SendTyped(recipient string, v any) error {
+	msg, err := Encode(m.reg, v)
+	if err != nil {
+		return err
+	}
+	msg.Recipient = recipient
+	return m.ms.SendMessage(msg)
+}
+
+// Send sends a plain message to recipient.
+func (m *Messenger) Send(recipient string, data []byte) error {
+	return m.ms.Send(recipient, data)
+}
+
+// Receiver returns a channel that receives the messages that are not
+// dispatched to a handler: plain messages, typed values with no
+// handler, and values that cannot be decoded.  Decode gives the
+// reason for the latter.
+func (m *Messenger) Receiver() <-chan *api.Message {
+	return m.receiver
+}
+
+// Close closes the Messenger and its service.  Calling Close more
+// than once returns the result of the first call.
+func (m *Messenger) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.closeErr = m.ms.Close()
+		m.wg.Wait()
+	})
+	return m.closeErr
+}
+
+// Handle registers h to receive the values of type T sent to m,
+// replacing any handler already registered for T.  T must be
+// registered in m's Registry, either as itself or, if T is a pointer
+// type, as the type it points to.  Handlers are called one at a time,
+// in the order in which values arrive.
+func Handle[T any](m *Messenger, h func(sender string, v T)) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() == reflect.Pointer && typ.Elem().Kind() == reflect.Pointer {
+		panic(fmt.Sprintf("typed: Handle for pointer to pointer type %v", typ))
+	}
+	if _, ok := m.reg.lookupType(reflect.New(typ).Interface()); !ok {
+		panic(fmt.Sprintf("typed: Handle for unregistered type %v", typ))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[baseType(typ)] = func(sender string, v reflect.Value) {
+		// v is a pointer to a value of the registered type;
+		// follow it as far as T requires.
+		for v.Type() != typ {
+			v = v.Elem()
+		}
+		h(sender, v.Interface().(T))
+	}
+}
+
+// Encode returns a message holding v in an envelope.  The caller
+// sets its recipient.
+func Encode(reg *Registry, v any) (*api.Message, error) {
+	r, ok := reg.lookupType(v)
+	if !ok {
+		return nil, fmt.Errorf("type %T is not registered", v)
+	}
+	body, err := r.codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %v", r.name, err)
+	}
+	return envelope.New(envelope.Typed, &api.Typed{ContentType: r.codec.ContentType(), Type: r.name, Body: body})
+}
+
+// Decode returns the value held by msg, as a pointer to a value of
+// its registered type.  It returns ErrNotTyped if msg holds no typed
+// value.
+func Decode(reg *Registry, msg *api.Message) (any, error) {
+	v, err := decode(reg, msg)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// decode is Decode returning a reflect.Value.
+func decode(reg *Registry, msg *api.Message) (reflect.Value, error) {
+	env := &api.Typed{}
+	if !envelope.Open(msg, envelope.Typed, env) {
+		return reflect.Value{}, ErrNotTyped
+	}
+	r, codec, ok := reg.lookupName(env.Type, env.ContentType)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown type %q or content type %q", env.Type, env.ContentType)
+	}
+	v := reflect.New(r.typ)
+	if err := codec.Unmarshal(env.Body, v.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("decoding %s: %v", r.name, err)
+	}
+	return v, nil
+}
+
+// dispatch passes received values to their handlers until the
+// service's Receiver channel is closed.
+func (m *Messenger) dispatch() {
+	defer m.wg.Done()
+	defer close(m.receiver)
+	for msg := range m.ms.Receiver() {
+		if v, err := decode(m.reg, msg); err == nil {
+			m.mu.RLock()
+			h, ok := m.handlers[v.Type().Elem()]
+			m.mu.RUnlock()
+			if ok {
+				h(msg.Sender, v)
+				continue
+			}
+		}
+		select {
+		case m.receiver <- msg:
+		case <-m.done:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package typed
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/impl"
+	"cse586.messageservice/impl/impltest"
+)
+
+type Reading struct {
+	Sensor string
+	Value  float64
+}
+
+type Command struct {
+	Name string
+	Args []string
+}
+
+type Unhandled struct {
+	N int
+}
+
+// newRegistry registers the test types, one with each codec.
+func newRegistry(t *testing.T) *Registry {
+	t.Helper()
+	reg := NewRegistry()
+	for _, r := range []struct {
+		sample any
+		codec  Codec
+	}{
+		{Reading{}, JSON},
+		{&Command{}, Gob},
+		{&api.Publication{}, Proto},
+		{Unhandled{}, JSON},
+	} {
+		if err := reg.Register(r.sample, r.codec); err != nil {
+			t.Fatalf("Register(%T) failed: %v", r.sample, err)
+		}
+	}
+	if err := reg.Register(Reading{}, Gob); err == nil {
+		t.Error("Type was registered twice")
+	}
+	return reg
+}
+
+// TestTypedMessaging sends values of each registered type, along
+// with a plain message, and checks that each reaches its handler or
+// the Receiver channel.
+func TestTypedMessaging(t *testing.T) {
+	opts := impl.Options{Directory: impltest.Directory(t, "alice", "bob")}
+	m := impltest.Start(t, func(id string) (*Messenger, error) {
+		ms, err := impl.NewMessageServiceWithOptions(id, opts)
+		if err != nil {
+			return nil, err
+		}
+		return New(ms, newRegistry(t)), nil
+	}, "alice", "bob")
+	alice, bob := m[0], m[1]
+
+	got := make(chan any, 3)
+	Handle(bob, func(sender string, r Reading) { got <- r })
+	Handle(bob, func(sender string, c *Command) { got <- *c })
+	Handle(bob, func(sender string, p *api.Publication) { got <- p.Topic })
+
+	for _, v := range []any{
+		Reading{"temp", 21.5},
+		&Command{"restart", []string{"now"}},
+		&api.Publication{Topic: "lab/2"},
+		Unhandled{7},
+	} {
+		if err := alice.SendTyped("bob", v); err != nil {
+			t.Fatalf("SendTyped(%T) failed: %v", v, err)
+		}
+	}
+	if err := alice.Send("bob", []byte("plain")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := alice.SendTyped("bob", struct{}{}); err == nil {
+		t.Error("SendTyped of an unregistered type succeeded")
+	}
+
+	for _, want := range []string{"{temp 21.5}", "{restart [now]}", "lab/2"} {
+		select {
+		case v := <-got:
+			if s := fmt.Sprint(v); s != want {
+				t.Errorf("Handler received %s, expected %s", s, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Handler did not receive %s", want)
+		}
+	}
+
+	msg := <-bob.Receiver()
+	if v, err := Decode(bob.reg, msg); err != nil || v.(*Unhandled).N != 7 {
+		t.Errorf("Decode = %v, %v", v, err)
+	}
+	msg = <-bob.Receiver()
+	if _, err := Decode(bob.reg, msg); err != ErrNotTyped || string(msg.Data) != "plain" {
+		t.Errorf("Unexpected plain message %v: %v", msg, err)
+	}
+
+	// Close may be called concurrently, and more than once.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bob.Close()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,126 @@
+package impl
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"cse586.messageservice/api"
+)
+
+// maxUDPPayload is the largest payload that a UDP datagram can carry
+// over IPv4.
+const maxUDPPayload = 65507
+
+// datagramNetwork returns the datagram counterpart of a stream
+// network from splitAddress.
+func datagramNetwork(network string) string {
+	switch network {
+	case "tcp4":
+		return "udp4"
+	case "tcp6":
+		return "udp6"
+	case "unix":
+		return "unixgram"
+	}
+	return "udp"
+}
+
+// unixgramConn is a Unix datagram socket that removes its socket
+// file when closed, as a Unix stream listener does.
+type unixgramConn struct {
+	net.PacketConn
+	path string
+}
+
+func (c *unixgramConn) Close() error {
+	err := c.PacketConn.Close()
+	os.Remove(c.path)
+	return err
+}
+
+// listenDatagram opens a datagram socket on a directory address.
+func listenDatagram(addr string) (net.PacketConn, error) {
+	network, address := splitAddress(addr)
+	network = datagramNetwork(network)
+	if network != "unixgram" {
+		return net.ListenPacket(network, address)
+	}
+
+	if err := removeStaleSocket(network, address); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &unixgramConn{conn, address}, nil
+}
+
+// listenDatagram receives datagrams on conn until it is closed.  Each
+// datagram must hold exactly one frame, as produced by marshalFrame;
+// datagrams whose length header disagrees with their size, or that
+// do not hold a valid message, are reported and discarded.
+func (ms *messageService) listenDatagram(conn net.PacketConn) {
+	defer ms.end()
+	// The buffer has room for one byte more than the largest
+	// frame, so that an oversized datagram is not silently
+	// truncated to a valid length.
+	buf := make([]byte, frameHeaderLen+0xffff+1)
+	for {
+		n, remote, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ms.isClosed() {
+				return
+			}
+			ms.reportError(ReadFailed, remote, err)
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return
+		}
+		msg, err := unmarshalFrame(buf[:n])
+		if err != nil {
+			ms.reportError(receiveErrorKind(err), remote, err)
+			continue
+		}
+		if msg.Kind != api.Kind_DATA {
+			ms.reportError(UnmarshalFailed, remote, unexpectedKind(msg))
+			continue
+		}
+		// There is no connection on which to report a
+		// rejection, so a rejected datagram is just counted.
+		ms.accept(msg, remote)
+	}
+}
+
+// sendDatagram sends a frame to recipient as a single datagram.  As
+// a datagram send cannot tell whether an address works, only the
+// recipient's preferred address is used.  A frame too long for a UDP
+// datagram is refused with api.MessageTooLong.
+func (ms *messageService) sendDatagram(recipient string, frame []byte) error {
+	addrs, ok := ms.lookups.lookup(recipient)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownRecipient, recipient)
+	}
+	network, address := splitAddress(ms.preferLast(recipient, addrs)[0])
+	network = datagramNetwork(network)
+	if network != "unixgram" && len(frame) > maxUDPPayload {
+		return tooLong(len(frame) - frameHeaderLen)
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	if !ms.track(conn) {
+		conn.Close()
+		return errClosed
+	}
+	defer ms.untrack(conn)
+	defer conn.Close()
+
+	if _, err := conn.Write(frame); err != nil {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package impl
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"cse586.messageservice/api"
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestDatagramTransport exchanges messages between two datagram
+// services, one on UDP and one on a Unix datagram socket, and
+// ensures that malformed datagrams are discarded and that a frame
+// too long for a UDP datagram is refused.
+func TestDatagramTransport(t *testing.T) {
+	dir := directory.NewStatic(map[string]string{
+		"alice": impltest.FreeAddr(t),
+		"bob":   "unix:" + filepath.Join(t.TempDir(), "bob.sock"),
+	})
+	opts := Options{Directory: dir, Transport: DatagramTransport}
+	alice, err := NewMessageServiceWithOptions("alice", opts)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", opts)
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer bob.Close()
+
+	if err := alice.Send("bob", []byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if rmsg := <-bob.Receiver(); rmsg.Sender != "alice" || string(rmsg.Data) != "ping" {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+
+	addr, _ := dir.Lookup("alice")
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(staticMsg[:len(staticMsg)-1])   // truncated
+	conn.Write(append(staticMsg[:], 0))        // trailing garbage
+	conn.Write([]byte{0, 3, 0xff, 0xff, 0xff}) // not a message
+	if err := bob.Send("alice", []byte("pong")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if rmsg := <-alice.Receiver(); rmsg.Sender != "bob" || string(rmsg.Data) != "pong" {
+		t.Errorf("Malformed datagram was delivered: %v", rmsg)
+	}
+
+	// A well-formed datagram from any source is accepted.
+	conn.Write(staticMsg[:])
+	if rmsg := <-alice.Receiver(); rmsg.Sender != staticMsgSender {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+
+	var tooLong *api.MessageTooLong
+	if err := bob.Send("alice", make([]byte, maxUDPPayload)); !errors.As(err, &tooLong) {
+		t.Errorf("Send of an oversized datagram returned %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package impl
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenAddress opens a listener on a directory address.  For Unix
+// domain sockets, a socket file left behind by a process that exited
+// without closing its service is removed first, and the listener is
+// set to remove its socket file when it is closed.
+func listenAddress(addr string) (net.Listener, error) {
+	network, address := splitAddress(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	if err := removeStaleSocket(network, address); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket file at path if nothing is
+// listening on it with the given network ("unix" or "unixgram").  A
+// live socket is left alone, so that the subsequent listen fails as
+// it would for a TCP port in use.
+func removeStaleSocket(network, path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		// Missing files are fine, and other files are left
+		// for net.Listen to complain about.
+		return nil
+	}
+	conn, err := net.Dial(network, path)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package impl
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cse586.messageservice/given/directory"
+	"cse586.messageservice/impl/impltest"
+)
+
+// TestUnixSocket exchanges messages between a TCP service and a
+// Unix domain socket service, and ensures that the socket file is
+// removed on Close.
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bob.sock")
+	dir := directory.NewStatic(map[string]string{
+		"alice": impltest.FreeAddr(t),
+		"bob":   "unix:" + path,
+	})
+	alice, err := NewMessageServiceWithOptions("alice", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+
+	if err := alice.Send("bob", []byte("ping")); err != nil {
+		t.Fatalf("Send over Unix socket failed: %v", err)
+	}
+	if rmsg := <-bob.Receiver(); string(rmsg.Data) != "ping" {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+	if err := bob.Send("alice", []byte("pong")); err != nil {
+		t.Fatalf("Send from Unix socket service failed: %v", err)
+	}
+	if rmsg := <-alice.Receiver(); string(rmsg.Data) != "pong" {
+		t.Errorf("Unexpected message %v", rmsg)
+	}
+
+	bob.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Socket file was not removed: %v", err)
+	}
+}
+
+// TestStaleUnixSocket leaves a socket file behind, as a crashed
+// process would, and ensures that a new service can still listen.
+func TestStaleUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	dir := directory.NewStatic(map[string]string{"bob": "unix:" + path})
+	bob, err := NewMessageServiceWithOptions("bob", Options{Directory: dir})
+	if err != nil {
+		t.Fatalf("Could not listen over stale socket: %v", err)
+	}
+	defer bob.Close()
+
+	if _, err := NewMessageServiceWithOptions("bob", Options{Directory: dir}); err == nil {
+		t.Error("Second service listened on a live socket")
+	}
+}